@@ -17,27 +17,45 @@ limitations under the License.
 package qrm
 
 import (
+	"fmt"
+	"strconv"
+	"time"
+
 	cliflag "k8s.io/component-base/cli/flag"
 
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
 	qrmconfig "github.com/kubewharf/katalyst-core/pkg/config/agent/qrm"
 )
 
 type CPUOptions struct {
-	PolicyName             string
-	ReservedCPUCores       int
-	SkipCPUStateCorruption bool
+	PolicyName              string
+	ReservedCPUCores        int
+	ReservedCPUCoresPerNUMA map[string]int
+	SkipCPUStateCorruption  bool
 
 	CPUDynamicPolicyOptions
 	CPUNativePolicyOptions
 }
 
 type CPUDynamicPolicyOptions struct {
-	EnableCPUAdvisor              bool
-	EnableCPUPressureEviction     bool
-	LoadPressureEvictionSkipPools []string
-	EnableSyncingCPUIdle          bool
-	EnableCPUIdle                 bool
+	EnableCPUAdvisor                  bool
+	EnableCPUPressureEviction         bool
+	LoadPressureEvictionSkipPools     []string
+	EnableSyncingCPUIdle              bool
+	EnableCPUIdle                     bool
+	StateInvariantCheckPeriod         time.Duration
+	EnableStateInvariantRebuild       bool
+	EnableNonPreferredHintPromotion   bool
+	NamespaceNUMABudget               map[string]int
+	SocketSelectionStrategy           string
+	EnableStrictNUMAExclusiveHints    bool
+	NUMAMemoryBandwidthBudget         int64
+	NUMAAllocationStrategy            string
+	EnableReclaimedDisplacementReport bool
+	HintPreferenceStrategy            string
+	BurstReservedNUMAsPerSocket       int
+	CrossSocketPolicy                 string
 }
 
 type CPUNativePolicyOptions struct {
@@ -55,6 +73,10 @@ func NewCPUOptions() *CPUOptions {
 			EnableCPUPressureEviction: false,
 			EnableSyncingCPUIdle:      false,
 			EnableCPUIdle:             false,
+			StateInvariantCheckPeriod: time.Minute,
+			SocketSelectionStrategy:   cpuconsts.CPUSocketSelectionStrategyBalance,
+			HintPreferenceStrategy:    cpuconsts.CPUHintPreferenceStrategyMinNUMAs,
+			CrossSocketPolicy:         cpuconsts.CPUCrossSocketPolicyPreferSameSocket,
 			LoadPressureEvictionSkipPools: []string{
 				state.PoolNameReclaim,
 				state.PoolNameDedicated,
@@ -77,6 +99,9 @@ func (o *CPUOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 		o.EnableCPUAdvisor, "Whether cpu resource plugin should enable sys-advisor")
 	fs.IntVar(&o.ReservedCPUCores, "cpu-resource-plugin-reserved",
 		o.ReservedCPUCores, "The total cores cpu resource plugin should reserve")
+	fs.StringToIntVar(&o.ReservedCPUCoresPerNUMA, "cpu-resource-plugin-reserved-per-numa", o.ReservedCPUCoresPerNUMA,
+		"The cores cpu resource plugin should reserve in specific NUMA nodes, e.g. 0=4, "+
+			"it overrides the part of --cpu-resource-plugin-reserved in those NUMA nodes")
 	fs.BoolVar(&o.SkipCPUStateCorruption, "skip-cpu-state-corruption",
 		o.SkipCPUStateCorruption, "if set true, we will skip cpu state corruption")
 	fs.BoolVar(&o.EnableCPUPressureEviction, "enable-cpu-pressure-eviction", o.EnableCPUPressureEviction,
@@ -88,6 +113,43 @@ func (o *CPUOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 	fs.BoolVar(&o.EnableCPUIdle, "enable-cpu-idle", o.EnableCPUIdle,
 		"if set true, we will enable cpu idle for "+
 			"specific cgroup paths and it requires --enable-syncing-cpu-idle=true to make effect")
+	fs.DurationVar(&o.StateInvariantCheckPeriod, "cpu-state-invariant-check-period", o.StateInvariantCheckPeriod,
+		"the interval to verify invariants of cpu plugin state, zero means disabled")
+	fs.BoolVar(&o.EnableStateInvariantRebuild, "enable-cpu-state-invariant-rebuild", o.EnableStateInvariantRebuild,
+		"if set true, we will rebuild cpu machine state from pod entries once state invariants are violated")
+	fs.BoolVar(&o.EnableNonPreferredHintPromotion, "enable-cpu-non-preferred-hint-promotion", o.EnableNonPreferredHintPromotion,
+		"if set true, we will promote the best non-preferred hint to preferred when no preferred hint exists "+
+			"for dedicated_cores with NUMA binding, otherwise leave it to topology manager to decide")
+	fs.StringToIntVar(&o.NamespaceNUMABudget, "cpu-namespace-numa-budget", o.NamespaceNUMABudget,
+		"the max count of NUMA nodes that NUMA-binding pods of each namespace can occupy on this node, "+
+			"e.g. ns1=1,ns2=2, namespaces not specified are unlimited")
+	fs.StringVar(&o.SocketSelectionStrategy, "cpu-socket-selection-strategy", o.SocketSelectionStrategy,
+		"the strategy (balance/pack) to choose among single-socket hints for dedicated_cores with NUMA binding, "+
+			"balance prefers the least-occupied socket and pack fills one socket before using the next")
+	fs.BoolVar(&o.EnableStrictNUMAExclusiveHints, "enable-cpu-strict-numa-exclusive-hints", o.EnableStrictNUMAExclusiveHints,
+		"if set true, numa_exclusive containers spanning multiple NUMA nodes only get hints composed of completely free NUMA nodes, "+
+			"and masks with NUMA nodes not needed by the request are rejected")
+	fs.Int64Var(&o.NUMAMemoryBandwidthBudget, "cpu-numa-memory-bandwidth-budget", o.NUMAMemoryBandwidthBudget,
+		"the memory bandwidth (in MB/s) that dedicated_cores with NUMA binding can reserve in each NUMA node by annotation, "+
+			"hints exhausting it will be non-preferred, and zero means no budget")
+	fs.StringVar(&o.NUMAAllocationStrategy, "cpu-numa-allocation-strategy", o.NUMAAllocationStrategy,
+		"the strategy (binpacking/spread) to order equally-sized preferred hints for dedicated_cores with NUMA binding, "+
+			"binpacking prefers NUMA nodes with the least available cpus and spread prefers the most, "+
+			"empty means ordering them by --cpu-hint-preference-strategy, which can't be set together except min_numas")
+	fs.BoolVar(&o.EnableReclaimedDisplacementReport, "enable-cpu-reclaimed-displacement-report", o.EnableReclaimedDisplacementReport,
+		"if set true, we will report the count of reclaimed cpus displaced by each hint of dedicated_cores with NUMA binding "+
+			"in annotations of hints response")
+	fs.StringVar(&o.HintPreferenceStrategy, "cpu-hint-preference-strategy", o.HintPreferenceStrategy,
+		"the strategy (min_numas/load_balancing) to decide preferred hints and their order for dedicated_cores with NUMA binding, "+
+			"min_numas orders hints with the minimal count of NUMA nodes by NUMA ids, "+
+			"and load_balancing orders them by the ratio of allocated cpus")
+	fs.IntVar(&o.BurstReservedNUMAsPerSocket, "cpu-burst-reserved-numas-per-socket", o.BurstReservedNUMAsPerSocket,
+		"the count of NUMA nodes with the least allocated cpus in each socket kept unallocated as burst headroom "+
+			"for dedicated_cores with NUMA binding, they are only used if no other placement exists, and zero means disabled")
+	fs.StringVar(&o.CrossSocketPolicy, "cpu-cross-socket-policy", o.CrossSocketPolicy,
+		"the policy (prefer_same_socket/forbid_cross_socket/allow_cross_socket) for hints of dedicated_cores with NUMA binding "+
+			"crossing sockets, prefer_same_socket only allows them if the request doesn't fit into a socket, "+
+			"forbid_cross_socket never allows them and allow_cross_socket always allows them")
 	fs.StringVar(&o.CPUAllocationOption, "cpu-allocation-option",
 		o.CPUAllocationOption, "The allocation option of cpu (packed/distributed). The default value is packed."+
 			"in cases where more than one NUMA node is required to satisfy the allocation.")
@@ -100,11 +162,52 @@ func (o *CPUOptions) ApplyTo(conf *qrmconfig.CPUQRMPluginConfig) error {
 	conf.PolicyName = o.PolicyName
 	conf.EnableCPUAdvisor = o.EnableCPUAdvisor
 	conf.ReservedCPUCores = o.ReservedCPUCores
+	conf.ReservedCPUCoresPerNUMA = make(map[int]int, len(o.ReservedCPUCoresPerNUMA))
+	for numaIDStr, reservedCores := range o.ReservedCPUCoresPerNUMA {
+		numaID, err := strconv.Atoi(numaIDStr)
+		if err != nil || numaID < 0 {
+			return fmt.Errorf("invalid NUMA id: %s in reserved cpu cores per NUMA", numaIDStr)
+		} else if reservedCores < 0 {
+			return fmt.Errorf("invalid reserved cpu cores: %d for NUMA: %d", reservedCores, numaID)
+		}
+		conf.ReservedCPUCoresPerNUMA[numaID] = reservedCores
+	}
 	conf.SkipCPUStateCorruption = o.SkipCPUStateCorruption
 	conf.EnableCPUPressureEviction = o.EnableCPUPressureEviction
 	conf.LoadPressureEvictionSkipPools = o.LoadPressureEvictionSkipPools
 	conf.EnableSyncingCPUIdle = o.EnableSyncingCPUIdle
 	conf.EnableCPUIdle = o.EnableCPUIdle
+	conf.StateInvariantCheckPeriod = o.StateInvariantCheckPeriod
+	conf.EnableStateInvariantRebuild = o.EnableStateInvariantRebuild
+	conf.EnableNonPreferredHintPromotion = o.EnableNonPreferredHintPromotion
+	conf.NamespaceNUMABudget = o.NamespaceNUMABudget
+	switch o.SocketSelectionStrategy {
+	case "", cpuconsts.CPUSocketSelectionStrategyBalance, cpuconsts.CPUSocketSelectionStrategyPack:
+	default:
+		return fmt.Errorf("invalid socket selection strategy: %s", o.SocketSelectionStrategy)
+	}
+	conf.SocketSelectionStrategy = o.SocketSelectionStrategy
+	conf.EnableStrictNUMAExclusiveHints = o.EnableStrictNUMAExclusiveHints
+	conf.NUMAMemoryBandwidthBudget = o.NUMAMemoryBandwidthBudget
+	switch o.NUMAAllocationStrategy {
+	case "", cpuconsts.CPUNUMAAllocationStrategyBinPacking, cpuconsts.CPUNUMAAllocationStrategySpread:
+	default:
+		return fmt.Errorf("invalid NUMA allocation strategy: %s", o.NUMAAllocationStrategy)
+	}
+	conf.NUMAAllocationStrategy = o.NUMAAllocationStrategy
+	conf.EnableReclaimedDisplacementReport = o.EnableReclaimedDisplacementReport
+	conf.HintPreferenceStrategy = o.HintPreferenceStrategy
+	if o.BurstReservedNUMAsPerSocket < 0 {
+		return fmt.Errorf("invalid burst reserved NUMAs per socket: %d", o.BurstReservedNUMAsPerSocket)
+	}
+	conf.BurstReservedNUMAsPerSocket = o.BurstReservedNUMAsPerSocket
+	switch o.CrossSocketPolicy {
+	case "", cpuconsts.CPUCrossSocketPolicyPreferSameSocket, cpuconsts.CPUCrossSocketPolicyForbidCrossSocket,
+		cpuconsts.CPUCrossSocketPolicyAllowCrossSocket:
+	default:
+		return fmt.Errorf("invalid cross socket policy: %s", o.CrossSocketPolicy)
+	}
+	conf.CrossSocketPolicy = o.CrossSocketPolicy
 	conf.EnableFullPhysicalCPUsOnly = o.EnableFullPhysicalCPUsOnly
 	conf.CPUAllocationOption = o.CPUAllocationOption
 	return nil
@@ -23,3 +23,120 @@ const (
 	// CPUResourcePluginPolicyNameNative is the name of the native policy.
 	CPUResourcePluginPolicyNameNative = "native"
 )
+
+const (
+	// CPUSocketSelectionStrategyBalance prefers the least-occupied socket among single-socket hints.
+	CPUSocketSelectionStrategyBalance = "balance"
+
+	// CPUSocketSelectionStrategyPack prefers the most-occupied socket among single-socket hints,
+	// so that one socket is filled before using the next.
+	CPUSocketSelectionStrategyPack = "pack"
+)
+
+const (
+	// CPUNUMAAllocationStrategyBinPacking orders equally-sized preferred hints by
+	// available cpus ascending, i.e. the best-fit NUMA nodes come first.
+	CPUNUMAAllocationStrategyBinPacking = "binpacking"
+
+	// CPUNUMAAllocationStrategySpread orders equally-sized preferred hints by
+	// available cpus descending, i.e. the worst-fit NUMA nodes come first.
+	CPUNUMAAllocationStrategySpread = "spread"
+)
+
+const (
+	// CPUHintPreferenceStrategyMinNUMAs prefers hints with the minimal count of NUMA nodes needed,
+	// ordered by NUMA ids.
+	CPUHintPreferenceStrategyMinNUMAs = "min_numas"
+
+	// CPUHintPreferenceStrategyLoadBalancing prefers hints with the minimal count of NUMA nodes needed,
+	// ordered by the ratio of allocated cpus ascending.
+	CPUHintPreferenceStrategyLoadBalancing = "load_balancing"
+)
+
+const (
+	// CPUCrossSocketPolicyPreferSameSocket rejects masks crossing sockets only if
+	// they consist of no more NUMA nodes than a socket has.
+	CPUCrossSocketPolicyPreferSameSocket = "prefer_same_socket"
+
+	// CPUCrossSocketPolicyForbidCrossSocket rejects all masks crossing sockets,
+	// so requests larger than a socket can't be allocated.
+	CPUCrossSocketPolicyForbidCrossSocket = "forbid_cross_socket"
+
+	// CPUCrossSocketPolicyAllowCrossSocket never rejects masks for crossing sockets.
+	CPUCrossSocketPolicyAllowCrossSocket = "allow_cross_socket"
+)
+
+const (
+	// PodAnnotationNUMAHintKey is set by scheduler with the NUMA nodes (in cpuset format, e.g. 2-3)
+	// chosen for dedicated_cores with NUMA binding, and the matching hint will be preferred.
+	PodAnnotationNUMAHintKey = "katalyst.kubewharf.io/numa_hint"
+
+	// PodAnnotationSidecarOwnCPUsKey opts sidecars of dedicated_cores with NUMA binding into
+	// cpus of their own, taken from NUMA nodes of the main container; it isn't supported for numa_exclusive.
+	PodAnnotationSidecarOwnCPUsKey    = "katalyst.kubewharf.io/sidecar_own_cpus"
+	PodAnnotationSidecarOwnCPUsEnable = "true"
+
+	// PodAnnotationFullPCPUsOnlyKey indicates that dedicated_cores with NUMA binding only want
+	// full physical cores, so hints are calculated by available sibling-complete cores.
+	PodAnnotationFullPCPUsOnlyKey    = "katalyst.kubewharf.io/full_pcpus_only"
+	PodAnnotationFullPCPUsOnlyEnable = "true"
+
+	// PodAnnotationMemoryBandwidthKey is the memory bandwidth (in MB/s) reserved by dedicated_cores
+	// with NUMA binding, it's split evenly among NUMA nodes of the container.
+	PodAnnotationMemoryBandwidthKey = "katalyst.kubewharf.io/memory_bandwidth"
+
+	// PodAnnotationStrictSingleNUMAMemoryPinKey indicates that the container wants memory strictly pinned
+	// to exactly one NUMA node, so only single-NUMA hints are generated and the request must fit into one NUMA.
+	PodAnnotationStrictSingleNUMAMemoryPinKey    = "katalyst.kubewharf.io/strict_single_numa_memory_pin"
+	PodAnnotationStrictSingleNUMAMemoryPinEnable = "true"
+
+	// PodAnnotationSharedPoolNUMAPreferenceKey opts shared_cores into hints preferring the NUMA nodes
+	// where their shared pool has the most cpus, otherwise there is no numa preference for shared_cores.
+	PodAnnotationSharedPoolNUMAPreferenceKey    = "katalyst.kubewharf.io/shared_pool_numa_preference"
+	PodAnnotationSharedPoolNUMAPreferenceEnable = "true"
+
+	// PodAnnotationCoordinatedNUMAKey is set by an external coordinator (e.g. for gang placement across nodes)
+	// with the NUMA nodes (in cpuset format) for dedicated_cores with NUMA binding, the matching hint will be
+	// the only hint returned, and the request is rejected if the NUMA nodes are infeasible.
+	PodAnnotationCoordinatedNUMAKey = "katalyst.kubewharf.io/coordinated_numa"
+
+	// PodAnnotationHintTraceKey opts dedicated_cores with NUMA binding into tracing of hint calculation,
+	// and the trace is set in hints response with ResourceHintsAnnotationHintTraceKey.
+	PodAnnotationHintTraceKey    = "katalyst.kubewharf.io/hint_trace"
+	PodAnnotationHintTraceEnable = "true"
+)
+
+const (
+	// ResourceHintsAnnotationReclaimedDisplacementKey is set in hints response of dedicated_cores
+	// with NUMA binding, its value is a json map from NUMA nodes of each hint (in cpuset format)
+	// to the count of reclaimed cpus that would be displaced if the hint is chosen.
+	ResourceHintsAnnotationReclaimedDisplacementKey = "katalyst.kubewharf.io/reclaimed_displacement"
+
+	// ResourceHintsAnnotationHintRejectionsKey is set in hints response of dedicated_cores with NUMA binding
+	// only if no hint is calculated, its value is a json map from NUMA nodes of each rejected mask
+	// (in cpuset format) to the reason why it's rejected.
+	ResourceHintsAnnotationHintRejectionsKey = "katalyst.kubewharf.io/hint_rejections"
+
+	// ResourceHintsAnnotationHintTraceKey is set in hints response of dedicated_cores with NUMA binding
+	// only if the pod opts into PodAnnotationHintTraceKey, its value is a json object recording where
+	// the hints come from, available cpus of each NUMA, each rejected mask and the returned hints.
+	ResourceHintsAnnotationHintTraceKey = "katalyst.kubewharf.io/hint_trace"
+)
+
+// const variables for reasons why a NUMA mask is rejected in hint calculation.
+const (
+	HintRejectionReasonInsufficientNUMAs   = "insufficient_numas"
+	HintRejectionReasonInsufficientCPUs    = "insufficient_cpus"
+	HintRejectionReasonSingleNUMAOnly      = "single_numa_only"
+	HintRejectionReasonExclusiveOccupied   = "exclusive_occupied"
+	HintRejectionReasonCrossSockets        = "cross_sockets"
+	HintRejectionReasonStrictExclusive     = "strict_exclusive"
+	HintRejectionReasonNamespaceNUMABudget = "namespace_numa_budget"
+)
+
+// const variables for cpu allocation reason and action identifiers in event.
+const (
+	EventReasonAllocationDiscarded = "CPUAllocationDiscarded"
+
+	EventActionRegeneratingHints = "RegeneratingHints"
+)
@@ -19,6 +19,7 @@ package dynamicpolicy
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 	maputil "k8s.io/kubernetes/pkg/util/maps"
 	"k8s.io/utils/clock"
@@ -66,6 +68,16 @@ const (
 	stateCheckPeriod  = 30 * time.Second
 	maxResidualTime   = 5 * time.Minute
 	syncCPUIdlePeriod = 30 * time.Second
+
+	numaMetricsEmitPeriod = 30 * time.Second
+)
+
+// phases of hints calculation in metrics
+const (
+	hintsCalculationPhaseTotal    = "total"
+	hintsCalculationPhaseTopology = "topology"
+	hintsCalculationPhaseFilter   = "filter"
+	hintsCalculationPhaseSort     = "sort"
 )
 
 var (
@@ -95,9 +107,13 @@ type DynamicPolicy struct {
 	started bool
 
 	emitter     metrics.MetricEmitter
+	recorder    events.EventRecorder
 	metaServer  *metaserver.MetaServer
 	machineInfo *machine.KatalystMachineInfo
 
+	// numaBindingUnsupportedErr is the reason why NUMA binding isn't usable on this machine, nil if usable
+	numaBindingUnsupportedErr error
+
 	advisorClient    advisorapi.CPUAdvisorClient
 	advisorConn      *grpc.ClientConn
 	advisorValidator *validator.CPUAdvisorValidator
@@ -114,18 +130,37 @@ type DynamicPolicy struct {
 
 	// those are parsed from configurations
 	// todo if we want to use dynamic configuration, we'd better not use self-defined conf
-	enableCPUAdvisor              bool
-	reservedCPUs                  machine.CPUSet
-	cpuAdvisorSocketAbsPath       string
-	cpuPluginSocketAbsPath        string
-	extraStateFileAbsPath         string
-	enableCPUIdle                 bool
-	enableSyncingCPUIdle          bool
-	reclaimRelativeRootCgroupPath string
-	qosConfig                     *generic.QoSConfiguration
-	dynamicConfig                 *dynamicconfig.DynamicAgentConfiguration
-	podDebugAnnoKeys              []string
-	transitionPeriod              time.Duration
+	enableCPUAdvisor                  bool
+	reservedCPUs                      machine.CPUSet
+	numaReservedCPUs                  map[int]machine.CPUSet
+	cpuAdvisorSocketAbsPath           string
+	cpuPluginSocketAbsPath            string
+	extraStateFileAbsPath             string
+	extraStateFileCache               *util.ExtraStateFileCache
+	enableCPUIdle                     bool
+	enableSyncingCPUIdle              bool
+	reclaimRelativeRootCgroupPath     string
+	qosConfig                         *generic.QoSConfiguration
+	dynamicConfig                     *dynamicconfig.DynamicAgentConfiguration
+	podDebugAnnoKeys                  []string
+	transitionPeriod                  time.Duration
+	stateInvariantCheckPeriod         time.Duration
+	enableStateInvariantRebuild       bool
+	enableNonPreferredHintPromotion   bool
+	namespaceNUMABudget               map[string]int
+	socketSelectionStrategy           string
+	enableStrictNUMAExclusiveHints    bool
+	numaMemoryBandwidthBudget         int64
+	enableReclaimedDisplacementReport bool
+	hintPreferenceStrategy            HintPreferenceStrategy
+	hintPreferenceStrategyName        string
+	numaAllocationStrategy            string
+	burstReservedNUMAsPerSocket       int
+	crossSocketPolicy                 string
+
+	// getMemoryNUMAAvailabilityProvider returns the provider used to align hints with memory,
+	// and hints are calculated by cpu only if it returns nil
+	getMemoryNUMAAvailabilityProvider func() util.MemoryNUMAAvailabilityProvider
 }
 
 func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration,
@@ -137,6 +172,24 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 			conf.ReservedCPUCores, reserveErr)
 	}
 
+	numaReservedCPUs, reserveErr := cpuutil.GetCoresReservedForSystemPerNUMA(conf, agentCtx.KatalystMachineInfo, reservedCPUs)
+	if reserveErr != nil {
+		return false, agent.ComponentStub{}, fmt.Errorf("GetCoresReservedForSystemPerNUMA for reservedCPUsNum: %v failed with error: %v",
+			conf.ReservedCPUCoresPerNUMA, reserveErr)
+	}
+
+	hintPreferenceStrategy, strategyErr := resolveHintPreferenceStrategy(conf.CPUQRMPluginConfig.HintPreferenceStrategy,
+		conf.CPUQRMPluginConfig.NUMAAllocationStrategy)
+	if strategyErr != nil {
+		return false, agent.ComponentStub{}, fmt.Errorf("resolveHintPreferenceStrategy failed with error: %v", strategyErr)
+	}
+
+	// reserved cpus of the whole machine consist of reserved cpus in each NUMA node
+	reservedCPUs = machine.NewCPUSet()
+	for _, cset := range numaReservedCPUs {
+		reservedCPUs = reservedCPUs.Union(cset)
+	}
+
 	stateImpl, stateErr := state.NewCheckpointState(conf.GenericQRMPluginConfiguration.StateFileDirectory, cpuPluginStateFileName,
 		cpuconsts.CPUResourcePluginPolicyNameDynamic, agentCtx.CPUTopology, conf.SkipCPUStateCorruption)
 	if stateErr != nil {
@@ -174,6 +227,7 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 
 		machineInfo: agentCtx.KatalystMachineInfo,
 		emitter:     wrappedEmitter,
+		recorder:    agentCtx.BroadcastAdapter.NewRecorder(agentName),
 		metaServer:  agentCtx.MetaServer,
 
 		state:          stateImpl,
@@ -183,18 +237,41 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 
 		cpuPressureEviction: cpuPressureEviction,
 
-		qosConfig:                     conf.QoSConfiguration,
-		dynamicConfig:                 conf.DynamicAgentConfiguration,
-		cpuAdvisorSocketAbsPath:       conf.CPUAdvisorSocketAbsPath,
-		cpuPluginSocketAbsPath:        conf.CPUPluginSocketAbsPath,
-		enableCPUAdvisor:              conf.CPUQRMPluginConfig.EnableCPUAdvisor,
-		reservedCPUs:                  reservedCPUs,
-		extraStateFileAbsPath:         conf.ExtraStateFileAbsPath,
-		enableSyncingCPUIdle:          conf.CPUQRMPluginConfig.EnableSyncingCPUIdle,
-		enableCPUIdle:                 conf.CPUQRMPluginConfig.EnableCPUIdle,
-		reclaimRelativeRootCgroupPath: conf.ReclaimRelativeRootCgroupPath,
-		podDebugAnnoKeys:              conf.PodDebugAnnoKeys,
-		transitionPeriod:              30 * time.Second,
+		qosConfig:                         conf.QoSConfiguration,
+		dynamicConfig:                     conf.DynamicAgentConfiguration,
+		cpuAdvisorSocketAbsPath:           conf.CPUAdvisorSocketAbsPath,
+		cpuPluginSocketAbsPath:            conf.CPUPluginSocketAbsPath,
+		enableCPUAdvisor:                  conf.CPUQRMPluginConfig.EnableCPUAdvisor,
+		reservedCPUs:                      reservedCPUs,
+		numaReservedCPUs:                  numaReservedCPUs,
+		extraStateFileAbsPath:             conf.ExtraStateFileAbsPath,
+		enableSyncingCPUIdle:              conf.CPUQRMPluginConfig.EnableSyncingCPUIdle,
+		enableCPUIdle:                     conf.CPUQRMPluginConfig.EnableCPUIdle,
+		reclaimRelativeRootCgroupPath:     conf.ReclaimRelativeRootCgroupPath,
+		podDebugAnnoKeys:                  conf.PodDebugAnnoKeys,
+		transitionPeriod:                  30 * time.Second,
+		stateInvariantCheckPeriod:         conf.CPUQRMPluginConfig.StateInvariantCheckPeriod,
+		enableStateInvariantRebuild:       conf.CPUQRMPluginConfig.EnableStateInvariantRebuild,
+		enableNonPreferredHintPromotion:   conf.CPUQRMPluginConfig.EnableNonPreferredHintPromotion,
+		namespaceNUMABudget:               conf.CPUQRMPluginConfig.NamespaceNUMABudget,
+		socketSelectionStrategy:           conf.CPUQRMPluginConfig.SocketSelectionStrategy,
+		enableStrictNUMAExclusiveHints:    conf.CPUQRMPluginConfig.EnableStrictNUMAExclusiveHints,
+		numaMemoryBandwidthBudget:         conf.CPUQRMPluginConfig.NUMAMemoryBandwidthBudget,
+		enableReclaimedDisplacementReport: conf.CPUQRMPluginConfig.EnableReclaimedDisplacementReport,
+		hintPreferenceStrategy:            hintPreferenceStrategy,
+		hintPreferenceStrategyName:        conf.CPUQRMPluginConfig.HintPreferenceStrategy,
+		numaAllocationStrategy:            conf.CPUQRMPluginConfig.NUMAAllocationStrategy,
+		burstReservedNUMAsPerSocket:       conf.CPUQRMPluginConfig.BurstReservedNUMAsPerSocket,
+		crossSocketPolicy:                 conf.CPUQRMPluginConfig.CrossSocketPolicy,
+		getMemoryNUMAAvailabilityProvider: util.GetMemoryNUMAAvailabilityProvider,
+	}
+
+	if policyImplement.extraStateFileAbsPath != "" {
+		policyImplement.extraStateFileCache = util.NewExtraStateFileCache(policyImplement.extraStateFileAbsPath, wrappedEmitter)
+	}
+
+	if policyImplement.numaBindingUnsupportedErr = checkNUMABindingCapability(agentCtx.CPUTopology); policyImplement.numaBindingUnsupportedErr != nil {
+		general.Warningf("NUMA binding isn't supported on this machine: %v", policyImplement.numaBindingUnsupportedErr)
 	}
 
 	// register allocation behaviors for pods with different QoS level
@@ -272,6 +349,18 @@ func (p *DynamicPolicy) Start() (err error) {
 	}, time.Second*30, p.stopCh)
 	go wait.Until(p.clearResidualState, stateCheckPeriod, p.stopCh)
 	go wait.Until(p.checkCPUSet, cpusetCheckPeriod, p.stopCh)
+	go wait.Until(p.emitNUMAMetrics, numaMetricsEmitPeriod, p.stopCh)
+
+	if p.stateInvariantCheckPeriod > 0 {
+		go wait.Until(p.checkStateInvariants, p.stateInvariantCheckPeriod, p.stopCh)
+	}
+
+	// reload hints in extra state file once it's changed
+	if p.extraStateFileCache != nil {
+		if err := p.extraStateFileCache.Run(p.stopCh); err != nil {
+			return fmt.Errorf("run extra state file cache failed with error: %v", err)
+		}
+	}
 
 	// start cpu-idle syncing if needed
 	if p.enableSyncingCPUIdle {
@@ -500,7 +589,8 @@ func (p *DynamicPolicy) GetTopologyAwareResources(_ context.Context,
 		},
 	}
 
-	if allocationInfo.CheckSideCar() {
+	// sidecars sharing cpus of the main container report no cpus, otherwise cpus would be counted twice
+	if allocationInfo.CheckSideCar() && !checkSidecarOwnCPUs(allocationInfo.Annotations) {
 		resp.ContainerTopologyAwareResources.AllocatedResources = map[string]*pluginapi.TopologyAwareResource{
 			string(v1.ResourceCPU): {
 				IsNodeResource:                    false,
@@ -574,6 +664,8 @@ func (p *DynamicPolicy) GetTopologyHints(ctx context.Context,
 	// since GetKatalystQoSLevelFromResourceReq function will filter annotations,
 	// we should do it before GetKatalystQoSLevelFromResourceReq.
 	isDebugPod := util.IsDebugPod(req.Annotations, p.podDebugAnnoKeys)
+	// cpu plugin specific annotations will also be filtered, so keep them for hint handlers
+	pluginAnnotations := getPluginAnnotations(req.Annotations)
 
 	qosLevel, err := util.GetKatalystQoSLevelFromResourceReq(p.qosConfig, req)
 	if err != nil {
@@ -583,9 +675,13 @@ func (p *DynamicPolicy) GetTopologyHints(ctx context.Context,
 		return nil, err
 	}
 
-	reqInt, err := util.GetQuantityFromResourceReq(req)
+	for key, val := range pluginAnnotations {
+		req.Annotations[key] = val
+	}
+
+	reqInt, reqMilliCPUs, err := util.GetCPUQuantityFromResourceReq(req)
 	if err != nil {
-		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+		return nil, fmt.Errorf("GetCPUQuantityFromResourceReq failed with error: %v", err)
 	}
 
 	general.InfoS("called",
@@ -597,6 +693,7 @@ func (p *DynamicPolicy) GetTopologyHints(ctx context.Context,
 		"containerType", req.ContainerType,
 		"qosLevel", qosLevel,
 		"numCPUs", reqInt,
+		"numMilliCPUs", reqMilliCPUs,
 		"isDebugPod", isDebugPod)
 
 	if req.ContainerType == pluginapi.ContainerType_INIT || isDebugPod {
@@ -645,6 +742,8 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 	// since GetKatalystQoSLevelFromResourceReq function will filter annotations,
 	// we should do it before GetKatalystQoSLevelFromResourceReq.
 	isDebugPod := util.IsDebugPod(req.Annotations, p.podDebugAnnoKeys)
+	// cpu plugin specific annotations will also be filtered, so keep them for allocation handlers
+	pluginAnnotations := getPluginAnnotations(req.Annotations)
 
 	qosLevel, err := util.GetKatalystQoSLevelFromResourceReq(p.qosConfig, req)
 	if err != nil {
@@ -654,9 +753,13 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 		return nil, err
 	}
 
-	reqInt, err := util.GetQuantityFromResourceReq(req)
+	for key, val := range pluginAnnotations {
+		req.Annotations[key] = val
+	}
+
+	reqInt, reqMilliCPUs, err := util.GetCPUQuantityFromResourceReq(req)
 	if err != nil {
-		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+		return nil, fmt.Errorf("GetCPUQuantityFromResourceReq failed with error: %v", err)
 	}
 
 	general.InfoS("called",
@@ -668,6 +771,7 @@ func (p *DynamicPolicy) Allocate(ctx context.Context,
 		"containerType", req.ContainerType,
 		"qosLevel", qosLevel,
 		"numCPUs", reqInt,
+		"numMilliCPUs", reqMilliCPUs,
 		"isDebugPod", isDebugPod)
 
 	if req.ContainerType == pluginapi.ContainerType_INIT {
@@ -1046,3 +1150,79 @@ func (p *DynamicPolicy) getContainerRequestedCores(allocationInfo *state.Allocat
 	}
 	return allocationInfo.RequestQuantity
 }
+
+// getNUMAReservedCPUs returns reserved cpus of the given NUMA node,
+// and falls back to the machine-wide reserved cpus if it isn't specified.
+func (p *DynamicPolicy) getNUMAReservedCPUs(numaID int) machine.CPUSet {
+	if numaReserved, ok := p.numaReservedCPUs[numaID]; ok {
+		return numaReserved
+	}
+	return p.reservedCPUs
+}
+
+// GetNamespaceNUMAUsage returns NUMA nodes occupied by NUMA-binding pods of each namespace,
+// it's used to show the usage of namespace NUMA budget in diagnostics
+func (p *DynamicPolicy) GetNamespaceNUMAUsage() map[string]machine.CPUSet {
+	p.RLock()
+	defer p.RUnlock()
+
+	return getNamespaceNUMAUsage(p.state.GetMachineState())
+}
+
+// NUMAAllocationConfig is the subset of configurations that constrain
+// placements of NUMA-binding pods, it's used to preview config changes
+type NUMAAllocationConfig struct {
+	NamespaceNUMABudget map[string]int `json:"namespaceNUMABudget,omitempty"`
+}
+
+// ConfigViolation describes a running pod that violates the previewed config
+type ConfigViolation struct {
+	PodUID       string `json:"podUID"`
+	PodNamespace string `json:"podNamespace"`
+	PodName      string `json:"podName"`
+	Reason       string `json:"reason"`
+}
+
+// ConfigImpactReport is the result of previewing a config change against current placements
+type ConfigImpactReport struct {
+	Violations []ConfigViolation `json:"violations"`
+}
+
+// PreviewConfig evaluates current allocations against the given config without applying it,
+// and reports pods that would violate it; it's read-only and computed on a snapshot of state.
+func (p *DynamicPolicy) PreviewConfig(newCfg NUMAAllocationConfig) ConfigImpactReport {
+	p.RLock()
+	podEntries := p.state.GetPodEntries()
+	machineState := p.state.GetMachineState()
+	p.RUnlock()
+
+	report := ConfigImpactReport{Violations: []ConfigViolation{}}
+	namespaceNUMAUsage := getNamespaceNUMAUsage(machineState)
+
+	podUIDs := make([]string, 0, len(podEntries))
+	for podUID := range podEntries {
+		podUIDs = append(podUIDs, podUID)
+	}
+	sort.Strings(podUIDs)
+
+	for _, podUID := range podUIDs {
+		mainContainerEntry := podEntries[podUID].GetMainContainerEntry()
+		if mainContainerEntry == nil || !state.CheckNUMABinding(mainContainerEntry) {
+			continue
+		}
+
+		budget, ok := newCfg.NamespaceNUMABudget[mainContainerEntry.PodNamespace]
+		usage := namespaceNUMAUsage[mainContainerEntry.PodNamespace]
+		if ok && usage.Size() > budget {
+			report.Violations = append(report.Violations, ConfigViolation{
+				PodUID:       podUID,
+				PodNamespace: mainContainerEntry.PodNamespace,
+				PodName:      mainContainerEntry.PodName,
+				Reason: fmt.Sprintf("namespace occupies NUMAs: %s which exceeds NUMA budget: %d",
+					usage.String(), budget),
+			})
+		}
+	}
+
+	return report
+}
@@ -263,10 +263,83 @@ func (p *DynamicPolicy) dedicatedCoresAllocationHandler(ctx context.Context,
 	}
 }
 
+// dedicatedCoresWithoutNUMABindingAllocationHandler records the container as to be isolated,
+// and isolated cpus are taken out of available cpus (across NUMA nodes) in adjustAllocationEntries;
+// if there are not enough cpus to isolate, the container will be put into fallback pool temporarily.
 func (p *DynamicPolicy) dedicatedCoresWithoutNUMABindingAllocationHandler(_ context.Context,
-	_ *pluginapi.ResourceRequest) (*pluginapi.ResourceAllocationResponse, error) {
-	// todo: support dedicated_cores without NUMA binding
-	return nil, fmt.Errorf("not support dedicated_cores without NUMA binding")
+	req *pluginapi.ResourceRequest) (*pluginapi.ResourceAllocationResponse, error) {
+	reqInt, err := util.GetQuantityFromResourceReq(req)
+	if err != nil {
+		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+	}
+
+	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	err = updateAllocationInfoByReq(req, allocationInfo)
+	if err != nil {
+		general.Errorf("pod: %s/%s, container: %s updateAllocationInfoByReq failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return nil, fmt.Errorf("updateAllocationInfoByReq failed with error: %v", err)
+	}
+
+	if allocationInfo == nil {
+		allocationInfo = &state.AllocationInfo{
+			PodUid:                           req.PodUid,
+			PodNamespace:                     req.PodNamespace,
+			PodName:                          req.PodName,
+			ContainerName:                    req.ContainerName,
+			ContainerType:                    req.ContainerType.String(),
+			ContainerIndex:                   req.ContainerIndex,
+			PodRole:                          req.PodRole,
+			PodType:                          req.PodType,
+			OwnerPoolName:                    advisorapi.EmptyOwnerPoolName,
+			AllocationResult:                 machine.NewCPUSet(),
+			OriginalAllocationResult:         machine.NewCPUSet(),
+			TopologyAwareAssignments:         make(map[int]machine.CPUSet),
+			OriginalTopologyAwareAssignments: make(map[int]machine.CPUSet),
+			InitTimestamp:                    time.Now().Format(util.QRMTimeFormat),
+			QoSLevel:                         apiconsts.PodAnnotationQoSLevelDedicatedCores,
+			Labels:                           general.DeepCopyMap(req.Labels),
+			Annotations:                      general.DeepCopyMap(req.Annotations),
+			RequestQuantity:                  reqInt,
+		}
+	} else {
+		// the request is changed, try to isolate it again with the latest quantity
+		allocationInfo.OwnerPoolName = advisorapi.EmptyOwnerPoolName
+		allocationInfo.RequestQuantity = reqInt
+	}
+
+	// update pod entries directly.
+	// if one of subsequent steps is failed, we will delete current allocationInfo from podEntries in defer function of allocation function.
+	p.state.SetAllocationInfo(allocationInfo.PodUid, allocationInfo.ContainerName, allocationInfo)
+
+	err = p.adjustAllocationEntries()
+	if err != nil {
+		general.Errorf("pod: %s/%s, container: %s adjustAllocationEntries failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return nil, fmt.Errorf("adjustAllocationEntries failed with error: %v", err)
+	}
+
+	allocationInfo = p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	if allocationInfo == nil {
+		return nil, fmt.Errorf("pod: %s/%s, container: %s entry missed after adjustAllocationEntries",
+			req.PodNamespace, req.PodName, req.ContainerName)
+	}
+
+	general.InfoS("allocate CPUs for dedicated_cores without NUMA binding",
+		"podNamespace", req.PodNamespace,
+		"podName", req.PodName,
+		"containerName", req.ContainerName,
+		"numCPUs", reqInt,
+		"ownerPoolName", allocationInfo.OwnerPoolName,
+		"result", allocationInfo.AllocationResult.String())
+
+	resp, err := cpuutil.PackAllocationResponse(allocationInfo, string(v1.ResourceCPU), util.OCIPropertyNameCPUSetCPUs, false, true, req)
+	if err != nil {
+		general.Errorf("pod: %s/%s, container: %s PackResourceAllocationResponseByAllocationInfo failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return nil, fmt.Errorf("PackResourceAllocationResponseByAllocationInfo failed with error: %v", err)
+	}
+	return resp, nil
 }
 
 func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx context.Context,
@@ -307,13 +380,6 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 		return nil, err
 	}
 
-	general.InfoS("allocate CPUs successfully",
-		"podNamespace", req.PodNamespace,
-		"podName", req.PodName,
-		"containerName", req.ContainerName,
-		"numCPUs", reqInt,
-		"result", result.String())
-
 	topologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, result)
 	if err != nil {
 		general.ErrorS(err, "unable to calculate topologyAwareAssignments",
@@ -325,6 +391,10 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 		return nil, err
 	}
 
+	if oldAllocationInfo != nil {
+		p.checkAllocationNUMAChanged(req, oldAllocationInfo, topologyAwareAssignments)
+	}
+
 	allocationInfo := &state.AllocationInfo{
 		PodUid:                           req.PodUid,
 		PodNamespace:                     req.PodNamespace,
@@ -366,6 +436,7 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 			req.PodNamespace, req.PodName, req.ContainerName, err)
 		return nil, fmt.Errorf("adjustAllocationEntries failed with error: %v", err)
 	}
+	p.auditNUMABindingAllocation(req, reqInt, allocationInfo)
 
 	resp, err := cpuutil.PackAllocationResponse(allocationInfo, string(v1.ResourceCPU), util.OCIPropertyNameCPUSetCPUs, false, true, req)
 	if err != nil {
@@ -376,7 +447,61 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationHandler(ctx conte
 	return resp, nil
 }
 
-// dedicatedCoresWithNUMABindingAllocationSidecarHandler currently we set cpuset of sidecar to the cpuset of its main container
+// auditNUMABindingAllocation emits one structured log line once NUMA-binding allocation is committed,
+// so that the whole decision can be found by grepping "cpu allocation audit". it only logs what the
+// allocation itself knows, i.e. the request, the hint chosen by topology manager, strategies and the result,
+// since hints returned for the request may be adjusted by others later. keys of this line are kept stable
+// for parsing, and new keys should only be appended.
+func (p *DynamicPolicy) auditNUMABindingAllocation(req *pluginapi.ResourceRequest, reqInt int,
+	allocationInfo *state.AllocationInfo) {
+	general.InfoS("cpu allocation audit",
+		"podUID", req.PodUid,
+		"podNamespace", req.PodNamespace,
+		"podName", req.PodName,
+		"containerName", req.ContainerName,
+		"qosLevel", allocationInfo.QoSLevel,
+		"numCPUs", reqInt,
+		"numaExclusive", qosutil.AnnotationsIndicateNUMAExclusive(req.Annotations),
+		"hintNUMAs", util.HintToIntArray(req.Hint),
+		"hintPreferred", req.Hint != nil && req.Hint.Preferred,
+		"socketSelectionStrategy", p.socketSelectionStrategy,
+		"result", allocationInfo.AllocationResult.String(),
+		"numaAllocationStrategy", p.numaAllocationStrategy,
+		"hintPreferenceStrategy", p.hintPreferenceStrategyName,
+		"crossSocketPolicy", p.crossSocketPolicy)
+}
+
+// checkAllocationNUMAChanged emits a metric if the container is re-allocated to NUMA nodes different from
+// its previous allocation after its allocation is discarded (e.g. the container is resized), since pods
+// placed according to the previous NUMA nodes may need to be reconciled.
+func (p *DynamicPolicy) checkAllocationNUMAChanged(req *pluginapi.ResourceRequest,
+	oldAllocationInfo *state.AllocationInfo, topologyAwareAssignments map[int]machine.CPUSet) {
+	oldNUMAs, newNUMAs := machine.NewCPUSet(), machine.NewCPUSet()
+	for numaID, cset := range oldAllocationInfo.TopologyAwareAssignments {
+		if cset.Size() > 0 {
+			oldNUMAs.Add(numaID)
+		}
+	}
+	for numaID, cset := range topologyAwareAssignments {
+		if cset.Size() > 0 {
+			newNUMAs.Add(numaID)
+		}
+	}
+
+	if oldNUMAs.Equals(newNUMAs) {
+		return
+	}
+
+	general.Infof("pod: %s/%s, container: %s NUMA nodes change from: %s to: %s after re-allocation",
+		req.PodNamespace, req.PodName, req.ContainerName, oldNUMAs.String(), newNUMAs.String())
+	_ = p.emitter.StoreInt64(util.MetricNameAllocationNUMAChanged, 1, metrics.MetricTypeNameRaw,
+		metrics.MetricTag{Key: "podNamespace", Val: req.PodNamespace},
+		metrics.MetricTag{Key: "podName", Val: req.PodName},
+		metrics.MetricTag{Key: "containerName", Val: req.ContainerName})
+}
+
+// dedicatedCoresWithNUMABindingAllocationSidecarHandler currently we set cpuset of sidecar to the cpuset of its main container,
+// unless the sidecar opts into its own cpus, then they are taken from NUMA nodes of its main container.
 func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationSidecarHandler(_ context.Context,
 	req *pluginapi.ResourceRequest) (*pluginapi.ResourceAllocationResponse, error) {
 	reqInt, err := util.GetQuantityFromResourceReq(req)
@@ -420,6 +545,35 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationSidecarHandler(_
 		RequestQuantity:                  reqInt,
 	}
 
+	if checkSidecarOwnCPUs(req.Annotations) {
+		result, err := p.allocateSidecarOwnCPUs(req, reqInt, mainContainerAllocationInfo)
+		if err != nil {
+			general.ErrorS(err, "unable to allocate own CPUs for sidecar",
+				"podNamespace", req.PodNamespace,
+				"podName", req.PodName,
+				"containerName", req.ContainerName,
+				"numCPUs", reqInt)
+			return nil, err
+		}
+
+		topologyAwareAssignments, err := machine.GetNumaAwareAssignments(p.machineInfo.CPUTopology, result)
+		if err != nil {
+			general.ErrorS(err, "unable to calculate topologyAwareAssignments",
+				"podNamespace", req.PodNamespace,
+				"podName", req.PodName,
+				"containerName", req.ContainerName,
+				"numCPUs", reqInt,
+				"result cpuset", result.String())
+			return nil, err
+		}
+
+		allocationInfo.OwnerPoolName = state.PoolNameDedicated
+		allocationInfo.AllocationResult = result.Clone()
+		allocationInfo.OriginalAllocationResult = result.Clone()
+		allocationInfo.TopologyAwareAssignments = topologyAwareAssignments
+		allocationInfo.OriginalTopologyAwareAssignments = machine.DeepcopyCPUAssignment(topologyAwareAssignments)
+	}
+
 	// update pod entries directly.
 	// if one of subsequent steps is failed, we will delete current allocationInfo from podEntries in defer function of allocation function.
 	p.state.SetAllocationInfo(allocationInfo.PodUid, allocationInfo.ContainerName, allocationInfo)
@@ -442,6 +596,41 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingAllocationSidecarHandler(_
 	return resp, nil
 }
 
+// allocateSidecarOwnCPUs takes cpus of the sidecar opting into its own cpus from available cpus
+// in NUMA nodes of its main container, and cpus of its existing allocation are released first.
+func (p *DynamicPolicy) allocateSidecarOwnCPUs(req *pluginapi.ResourceRequest, reqInt int,
+	mainContainerAllocationInfo *state.AllocationInfo) (machine.CPUSet, error) {
+	if qosutil.AnnotationsIndicateNUMAExclusive(mainContainerAllocationInfo.Annotations) {
+		return machine.NewCPUSet(), fmt.Errorf("main container is numa_exclusive, no cpu is left for sidecar in its NUMA nodes")
+	}
+
+	machineState := p.state.GetMachineState()
+	if p.state.GetAllocationInfo(req.PodUid, req.ContainerName) != nil {
+		p.state.Delete(req.PodUid, req.ContainerName)
+
+		var err error
+		machineState, err = generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, p.state.GetPodEntries())
+		if err != nil {
+			return machine.NewCPUSet(), fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
+		}
+	}
+
+	availableCPUs := machine.NewCPUSet()
+	for numaNode, cset := range mainContainerAllocationInfo.TopologyAwareAssignments {
+		if cset.Size() == 0 {
+			continue
+		}
+		availableCPUs = availableCPUs.Union(machineState[numaNode].GetAvailableCPUSet(p.getNUMAReservedCPUs(numaNode)))
+	}
+
+	result, err := calculator.TakeByTopology(p.machineInfo, availableCPUs, reqInt)
+	if err != nil {
+		return machine.NewCPUSet(), fmt.Errorf("take cpus for sidecar from available cpus: %s of main container NUMAs failed with err: %v",
+			availableCPUs.String(), err)
+	}
+	return result, nil
+}
+
 func (p *DynamicPolicy) allocateNumaBindingCPUs(numCPUs int, hint *pluginapi.TopologyHint,
 	machineState state.NUMANodeMap, reqAnnotations map[string]string) (machine.CPUSet, error) {
 	if hint == nil {
@@ -457,7 +646,7 @@ func (p *DynamicPolicy) allocateNumaBindingCPUs(numCPUs int, hint *pluginapi.Top
 	result := machine.NewCPUSet()
 	alignedAvailableCPUs := machine.CPUSet{}
 	for _, numaNode := range hint.Nodes {
-		alignedAvailableCPUs = alignedAvailableCPUs.Union(machineState[int(numaNode)].GetAvailableCPUSet(p.reservedCPUs))
+		alignedAvailableCPUs = alignedAvailableCPUs.Union(machineState[int(numaNode)].GetAvailableCPUSet(p.getNUMAReservedCPUs(int(numaNode))))
 	}
 
 	var alignedCPUs machine.CPUSet
@@ -480,10 +669,9 @@ func (p *DynamicPolicy) allocateNumaBindingCPUs(numCPUs int, hint *pluginapi.Top
 		}
 	}
 
-	general.InfoS("allocate by hints",
-		"hints", hint.Nodes,
-		"alignedAvailableCPUs", alignedAvailableCPUs.String(),
-		"alignedAllocatedCPUs", alignedCPUs)
+	// the decision is logged by auditNUMABindingAllocation once committed, so only log details here
+	general.InfofV(4, "allocate by hints: %v, alignedAvailableCPUs: %s, alignedAllocatedCPUs: %s",
+		hint.Nodes, alignedAvailableCPUs.String(), alignedCPUs.String())
 
 	// currently, result equals to alignedCPUs,
 	// maybe extend cpus not aligned to meet requirement later
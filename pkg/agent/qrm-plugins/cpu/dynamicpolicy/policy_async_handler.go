@@ -19,6 +19,7 @@ package dynamicpolicy
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -32,6 +33,7 @@ import (
 	cgroupcmutils "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
 )
 
 // checkCPUSet emit errors if the memory allocation falls into unexpected results
@@ -241,3 +243,150 @@ func (p *DynamicPolicy) syncCPUIdle() {
 			p.reclaimRelativeRootCgroupPath, p.enableCPUIdle, err)
 	}
 }
+
+// emitNUMAMetrics emits per-NUMA gauges (available cpus, allocated cpus, pod count and exclusive flag),
+// those values are calculated from a snapshot of machine state to avoid holding the lock during emission
+func (p *DynamicPolicy) emitNUMAMetrics() {
+	p.RLock()
+	machineState := p.state.GetMachineState()
+	p.RUnlock()
+
+	for numaID, numaState := range machineState {
+		if numaState == nil {
+			general.Warningf("NUMA: %d has nil state", numaID)
+			continue
+		}
+
+		podCount := 0
+		exclusive := int64(0)
+		for _, containerEntries := range numaState.PodEntries {
+			if containerEntries.IsPoolEntry() {
+				continue
+			}
+			podCount++
+
+			for _, allocationInfo := range containerEntries {
+				if allocationInfo != nil && state.CheckDedicated(allocationInfo) &&
+					qosutil.AnnotationsIndicateNUMAExclusive(allocationInfo.Annotations) {
+					exclusive = 1
+				}
+			}
+		}
+
+		tags := metrics.ConvertMapToTags(map[string]string{
+			"numaID":   strconv.Itoa(numaID),
+			"socketID": p.machineInfo.CPUDetails.SocketsInNUMANodes(numaID).String(),
+		})
+
+		_ = p.emitter.StoreInt64(util.MetricNameNUMAAvailableCPUs,
+			int64(numaState.GetAvailableCPUSet(p.getNUMAReservedCPUs(numaID)).Size()), metrics.MetricTypeNameRaw, tags...)
+		_ = p.emitter.StoreInt64(util.MetricNameNUMAAllocatedCPUs,
+			int64(numaState.AllocatedCPUSet.Size()), metrics.MetricTypeNameRaw, tags...)
+		_ = p.emitter.StoreInt64(util.MetricNameNUMAPodCount, int64(podCount), metrics.MetricTypeNameRaw, tags...)
+		_ = p.emitter.StoreInt64(util.MetricNameNUMAExclusive, exclusive, metrics.MetricTypeNameRaw, tags...)
+	}
+}
+
+// checkStateInvariants verifies invariants of machine state and pod entries, and emits
+// a metric for each violation found; if enableStateInvariantRebuild is set,
+// machine state will be rebuilt from pod entries once any violation exists.
+func (p *DynamicPolicy) checkStateInvariants() {
+	general.Infof("exec checkStateInvariants")
+
+	p.RLock()
+	podEntries := p.state.GetPodEntries()
+	machineState := p.state.GetMachineState()
+	p.RUnlock()
+
+	violations := p.getStateInvariantViolations(podEntries, machineState)
+	if len(violations) == 0 {
+		return
+	}
+
+	for _, violation := range violations {
+		general.Errorf("cpu state invariant violated: %s", violation)
+	}
+	_ = p.emitter.StoreInt64(util.MetricNameStateInvariantViolated, int64(len(violations)), metrics.MetricTypeNameRaw)
+
+	if !p.enableStateInvariantRebuild {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// pod entries may be changed after the snapshot, so always rebuild with the latest ones
+	updatedMachineState, err := generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, p.state.GetPodEntries())
+	if err != nil {
+		general.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
+		return
+	}
+	p.state.SetMachineState(updatedMachineState)
+	general.Infof("rebuild machine state after invariants violated")
+}
+
+// getStateInvariantViolations returns descriptions of the violated invariants, including:
+// - no NUMA node is allocated beyond its cpus, or allocated with cpus of other NUMA nodes
+// - reserved cpus are disjoint with allocated cpus
+// - topology-aware assignments of each allocation refer to valid NUMA nodes
+// - machine state equals to the one regenerated from pod entries
+func (p *DynamicPolicy) getStateInvariantViolations(podEntries state.PodEntries, machineState state.NUMANodeMap) []string {
+	var violations []string
+
+	for numaID, numaState := range machineState {
+		if numaState == nil {
+			violations = append(violations, fmt.Sprintf("NUMA: %d has nil state", numaID))
+			continue
+		}
+
+		numaCPUs := p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID)
+		if !numaState.AllocatedCPUSet.IsSubsetOf(numaCPUs) {
+			violations = append(violations, fmt.Sprintf("NUMA: %d allocated cpuset: %s exceeds NUMA cpuset: %s",
+				numaID, numaState.AllocatedCPUSet.String(), numaCPUs.String()))
+		}
+
+		if overlap := numaState.AllocatedCPUSet.Intersection(p.reservedCPUs); overlap.Size() > 0 {
+			violations = append(violations, fmt.Sprintf("NUMA: %d allocated cpuset overlaps with reserved cpus: %s",
+				numaID, overlap.String()))
+		}
+	}
+
+	numaNodes := p.machineInfo.CPUDetails.NUMANodes()
+	for podUID, containerEntries := range podEntries {
+		for containerName, allocationInfo := range containerEntries {
+			if allocationInfo == nil {
+				continue
+			}
+
+			for numaID, cset := range allocationInfo.OriginalTopologyAwareAssignments {
+				if !numaNodes.Contains(numaID) {
+					violations = append(violations, fmt.Sprintf("pod: %s container: %s is assigned to invalid NUMA: %d",
+						podUID, containerName, numaID))
+				} else if !cset.IsSubsetOf(p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID)) {
+					violations = append(violations, fmt.Sprintf("pod: %s container: %s assignment: %s isn't in NUMA: %d",
+						podUID, containerName, cset.String(), numaID))
+				}
+			}
+		}
+	}
+
+	expectedMachineState, err := generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+	if err != nil {
+		return append(violations, fmt.Sprintf("GenerateMachineStateFromPodEntries failed with error: %v", err))
+	}
+
+	for numaID, expectedNUMAState := range expectedMachineState {
+		numaState := machineState[numaID]
+		if numaState == nil {
+			violations = append(violations, fmt.Sprintf("NUMA: %d is missing in machine state", numaID))
+		} else if !numaState.AllocatedCPUSet.Equals(expectedNUMAState.AllocatedCPUSet) ||
+			!numaState.DefaultCPUSet.Equals(expectedNUMAState.DefaultCPUSet) {
+			violations = append(violations, fmt.Sprintf("NUMA: %d state (allocated: %s, default: %s) "+
+				"mismatches with the one generated from pod entries (allocated: %s, default: %s)",
+				numaID, numaState.AllocatedCPUSet.String(), numaState.DefaultCPUSet.String(),
+				expectedNUMAState.AllocatedCPUSet.String(), expectedNUMAState.DefaultCPUSet.String()))
+		}
+	}
+
+	return violations
+}
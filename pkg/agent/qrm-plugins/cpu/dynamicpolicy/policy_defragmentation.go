@@ -0,0 +1,175 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"sort"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// NUMAMove recommends moving a container from one NUMA node to another
+type NUMAMove struct {
+	PodRef
+	ContainerName string
+	FromNUMA      int
+	ToNUMA        int
+}
+
+type movableContainer struct {
+	NUMAMove
+
+	// count of cpus occupied by the container
+	occupiedCPUs int
+}
+
+// DefragmentationPlan recommends moves of dedicated_cores with NUMA binding (but not exclusive) containers,
+// so that as many NUMA nodes as possible get totally free for large dedicated pods. NUMA nodes with the least
+// occupied cpus are emptied first, and each container goes to the occupied NUMA with the least available cpus
+// that can still hold it. a NUMA is only emptied if all of its containers can be moved.
+// it is only advisory and computed from a snapshot of state, the plan is never executed by the policy.
+func (p *DynamicPolicy) DefragmentationPlan() []NUMAMove {
+	p.RLock()
+	podEntries := p.state.GetPodEntries()
+	machineState := p.state.GetMachineState()
+	p.RUnlock()
+
+	reservedCPUs := p.getHintReservedCPUs()
+	numaAvailable := make(map[int]int, len(machineState))
+	for numaID, numaState := range machineState {
+		numaAvailable[numaID] = numaState.GetAvailableCPUSet(reservedCPUs).Size()
+	}
+
+	// NUMA nodes with pinned containers (e.g. numa_exclusive) can neither be emptied nor accept others
+	pinnedNUMAs := make(map[int]bool)
+	numaContainers := make(map[int][]*movableContainer)
+	for _, containerEntries := range podEntries {
+		if containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		for _, allocationInfo := range containerEntries {
+			if allocationInfo == nil || !state.CheckDedicatedNUMABinding(allocationInfo) || !allocationInfo.CheckMainContainer() {
+				continue
+			}
+
+			if qosutil.AnnotationsIndicateNUMAExclusive(allocationInfo.Annotations) ||
+				len(allocationInfo.TopologyAwareAssignments) != 1 {
+				for numaID := range allocationInfo.TopologyAwareAssignments {
+					pinnedNUMAs[numaID] = true
+				}
+				continue
+			}
+
+			for numaID := range allocationInfo.TopologyAwareAssignments {
+				numaContainers[numaID] = append(numaContainers[numaID], &movableContainer{
+					NUMAMove: NUMAMove{
+						PodRef: PodRef{
+							PodUID:       allocationInfo.PodUid,
+							PodNamespace: allocationInfo.PodNamespace,
+							PodName:      allocationInfo.PodName,
+						},
+						ContainerName: allocationInfo.ContainerName,
+						FromNUMA:      numaID,
+					},
+					occupiedCPUs: allocationInfo.AllocationResult.Size(),
+				})
+			}
+		}
+	}
+
+	numaOccupied := func(numaID int) int {
+		occupied := 0
+		for _, container := range numaContainers[numaID] {
+			occupied += container.occupiedCPUs
+		}
+		return occupied
+	}
+
+	sources := make([]int, 0, len(numaContainers))
+	for numaID := range numaContainers {
+		if !pinnedNUMAs[numaID] {
+			sources = append(sources, numaID)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if numaOccupied(sources[i]) != numaOccupied(sources[j]) {
+			return numaOccupied(sources[i]) < numaOccupied(sources[j])
+		}
+		return sources[i] < sources[j]
+	})
+
+	// NUMA nodes receiving moved containers won't be emptied anymore, so that each container moves at most once
+	emptiedNUMAs := make(map[int]bool)
+	receivingNUMAs := make(map[int]bool)
+	var plan []NUMAMove
+	for _, source := range sources {
+		if receivingNUMAs[source] {
+			continue
+		}
+
+		containers := numaContainers[source]
+		sort.SliceStable(containers, func(i, j int) bool {
+			return containers[i].occupiedCPUs > containers[j].occupiedCPUs
+		})
+
+		// place containers onto a copy of availability, and only commit if all of them are placed
+		available := make(map[int]int, len(numaAvailable))
+		for numaID, cpus := range numaAvailable {
+			available[numaID] = cpus
+		}
+
+		moves := make([]NUMAMove, 0, len(containers))
+		for _, container := range containers {
+			target := -1
+			for numaID := range available {
+				if numaID == source || emptiedNUMAs[numaID] || pinnedNUMAs[numaID] || len(numaContainers[numaID]) == 0 ||
+					available[numaID] < container.occupiedCPUs {
+					continue
+				}
+
+				if target < 0 || available[numaID] < available[target] ||
+					(available[numaID] == available[target] && numaID < target) {
+					target = numaID
+				}
+			}
+
+			if target < 0 {
+				break
+			}
+
+			available[target] -= container.occupiedCPUs
+			move := container.NUMAMove
+			move.ToNUMA = target
+			moves = append(moves, move)
+		}
+
+		if len(moves) != len(containers) {
+			continue
+		}
+
+		for _, move := range moves {
+			receivingNUMAs[move.ToNUMA] = true
+		}
+		numaAvailable = available
+		emptiedNUMAs[source] = true
+		plan = append(plan, moves...)
+	}
+
+	return plan
+}
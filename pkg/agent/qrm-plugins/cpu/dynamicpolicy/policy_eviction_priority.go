@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+)
+
+// PodRef identifies a pod recorded in cpu plugin state
+type PodRef struct {
+	PodUID       string
+	PodNamespace string
+	PodName      string
+}
+
+type evictionCandidate struct {
+	PodRef
+
+	// count of NUMA nodes that will be totally free after the pod is evicted
+	freedNUMAs int
+	// count of cpus occupied by the pod
+	occupiedCPUs int
+}
+
+// EvictionPriority ranks the given pods by how much evicting them relieves cpu pressure
+// while least disrupting NUMA placement. pods that free whole NUMA nodes come first,
+// then pods that occupy more cpus; the remaining ties are broken by namespace/name.
+// it is only advisory and computed from a snapshot of state, pods unknown to state are skipped.
+func (p *DynamicPolicy) EvictionPriority(podUIDs []string) []PodRef {
+	p.RLock()
+	podEntries := p.state.GetPodEntries()
+	machineState := p.state.GetMachineState()
+	p.RUnlock()
+
+	// key by numa node id, value is uids of pods (not pools) with cpus in the NUMA
+	numaPods := make(map[int]sets.String, len(machineState))
+	for numaID, numaState := range machineState {
+		numaPods[numaID] = sets.NewString()
+		if numaState == nil {
+			continue
+		}
+
+		for podUID, containerEntries := range numaState.PodEntries {
+			if containerEntries.IsPoolEntry() {
+				continue
+			}
+			numaPods[numaID].Insert(podUID)
+		}
+	}
+
+	candidates := make([]*evictionCandidate, 0, len(podUIDs))
+	for _, podUID := range sets.NewString(podUIDs...).List() {
+		containerEntries := podEntries[podUID]
+		if len(containerEntries) == 0 || containerEntries.IsPoolEntry() {
+			continue
+		}
+
+		candidate := &evictionCandidate{}
+		for _, allocationInfo := range containerEntries {
+			if allocationInfo == nil {
+				continue
+			}
+
+			candidate.PodRef = PodRef{
+				PodUID:       allocationInfo.PodUid,
+				PodNamespace: allocationInfo.PodNamespace,
+				PodName:      allocationInfo.PodName,
+			}
+
+			if state.CheckDedicated(allocationInfo) {
+				// sidecars of dedicated_cores share the cpuset with the main container
+				if allocationInfo.CheckMainContainer() {
+					candidate.occupiedCPUs += allocationInfo.AllocationResult.Size()
+				}
+			} else {
+				candidate.occupiedCPUs += allocationInfo.RequestQuantity
+			}
+		}
+
+		for _, pods := range numaPods {
+			if pods.Len() == 1 && pods.Has(podUID) {
+				candidate.freedNUMAs++
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].freedNUMAs != candidates[j].freedNUMAs {
+			return candidates[i].freedNUMAs > candidates[j].freedNUMAs
+		}
+
+		if candidates[i].occupiedCPUs != candidates[j].occupiedCPUs {
+			return candidates[i].occupiedCPUs > candidates[j].occupiedCPUs
+		}
+
+		if candidates[i].PodNamespace != candidates[j].PodNamespace {
+			return candidates[i].PodNamespace < candidates[j].PodNamespace
+		}
+		return candidates[i].PodName < candidates[j].PodName
+	})
+
+	res := make([]PodRef, 0, len(candidates))
+	for _, candidate := range candidates {
+		res = append(res, candidate.PodRef)
+	}
+	return res
+}
@@ -18,19 +18,27 @@ package dynamicpolicy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 	"k8s.io/kubernetes/pkg/kubelet/cm/topologymanager/bitmask"
 
 	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	advisorapi "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/cpuadvisor"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
 	cpuutil "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/util"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	"github.com/kubewharf/katalyst-core/pkg/util/native"
 	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
 )
 
@@ -40,15 +48,127 @@ func (p *DynamicPolicy) sharedCoresHintHandler(_ context.Context,
 		return nil, fmt.Errorf("got nil request")
 	}
 
+	if req.Annotations[cpuconsts.PodAnnotationSharedPoolNUMAPreferenceKey] == cpuconsts.PodAnnotationSharedPoolNUMAPreferenceEnable &&
+		req.ContainerType == pluginapi.ContainerType_MAIN {
+		poolName := state.GetSpecifiedPoolName(apiconsts.PodAnnotationQoSLevelSharedCores,
+			req.Annotations[apiconsts.PodAnnotationCPUEnhancementCPUSet])
+		if hints := p.calculateSharedPoolHints(poolName); hints != nil {
+			general.InfofV(4, "pod: %s/%s, container: %s, calculated hints: %v by shared pool: %s",
+				req.PodNamespace, req.PodName, req.ContainerName, hints, poolName)
+			return util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
+		}
+	}
+
 	return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
 		map[string]*pluginapi.ListOfTopologyHints{
 			string(v1.ResourceCPU): nil, // indicates that there is no numa preference
 		})
 }
 
-func (p *DynamicPolicy) reclaimedCoresHintHandler(ctx context.Context,
+// calculateSharedPoolHints generates one single-NUMA hint for each NUMA node where the given shared pool
+// has cpus, and only the ones with the largest count of pool cpus are preferred since they have the most
+// headroom for the pool; nil is returned if the pool doesn't exist or has no cpus.
+func (p *DynamicPolicy) calculateSharedPoolHints(poolName string) map[string]*pluginapi.ListOfTopologyHints {
+	poolAllocationInfo := p.state.GetAllocationInfo(poolName, advisorapi.FakedContainerName)
+	if poolAllocationInfo == nil {
+		return nil
+	}
+
+	numaNodes := make([]int, 0, len(poolAllocationInfo.TopologyAwareAssignments))
+	maxPoolCPUs := 0
+	for numaNode, cset := range poolAllocationInfo.TopologyAwareAssignments {
+		if cset.IsEmpty() {
+			continue
+		}
+
+		numaNodes = append(numaNodes, numaNode)
+		if cset.Size() > maxPoolCPUs {
+			maxPoolCPUs = cset.Size()
+		}
+	}
+	if len(numaNodes) == 0 {
+		return nil
+	}
+	sort.Ints(numaNodes)
+
+	hints := map[string]*pluginapi.ListOfTopologyHints{
+		string(v1.ResourceCPU): {
+			Hints: make([]*pluginapi.TopologyHint, 0, len(numaNodes)),
+		},
+	}
+	for _, numaNode := range numaNodes {
+		hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
+			Nodes:     []uint64{uint64(numaNode)},
+			Preferred: poolAllocationInfo.TopologyAwareAssignments[numaNode].Size() == maxPoolCPUs,
+		})
+	}
+	return hints
+}
+
+// reclaimedCoresHintHandler prefers the NUMA nodes with the most available cpus for reclaimed_cores,
+// to keep colocated reclaimed workloads away from NUMA nodes occupied by NUMA-binding containers;
+// any other NUMA node is still allowed as non-preferred.
+func (p *DynamicPolicy) reclaimedCoresHintHandler(_ context.Context,
 	req *pluginapi.ResourceRequest) (*pluginapi.ResourceHintsResponse, error) {
-	return p.sharedCoresHintHandler(ctx, req)
+	if req == nil {
+		return nil, fmt.Errorf("got nil request")
+	}
+
+	// currently, we set cpuset of sidecar to the cpuset of its main container,
+	// so there is no numa preference here.
+	if req.ContainerType == pluginapi.ContainerType_SIDECAR {
+		return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
+			map[string]*pluginapi.ListOfTopologyHints{
+				string(v1.ResourceCPU): nil, // indicates that there is no numa preference
+			})
+	}
+
+	machineState := p.state.GetMachineState()
+	hints := p.calculateReclaimedHints(machineState)
+
+	general.InfofV(4, "pod: %s/%s, container: %s, calculated reclaimed hints: %v",
+		req.PodNamespace, req.PodName, req.ContainerName, hints)
+	return util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
+}
+
+// calculateReclaimedHints generates one single-NUMA hint for each NUMA node,
+// and only the ones with the largest count of available cpus are preferred.
+// if no NUMA node has available cpus, there is no NUMA preference, since reclaimed_cores
+// share cpus with others and they shouldn't be rejected for lack of preferred hints.
+func (p *DynamicPolicy) calculateReclaimedHints(machineState state.NUMANodeMap) map[string]*pluginapi.ListOfTopologyHints {
+	numaNodes := make([]int, 0, len(machineState))
+	for numaNode := range machineState {
+		numaNodes = append(numaNodes, numaNode)
+	}
+	sort.Ints(numaNodes)
+
+	maxAvailable := 0
+	numaAvailable := make(map[int]int, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		numaAvailable[numaNode] = machineState[numaNode].GetAvailableCPUSet(p.getNUMAReservedCPUs(numaNode)).Size()
+		if numaAvailable[numaNode] > maxAvailable {
+			maxAvailable = numaAvailable[numaNode]
+		}
+	}
+
+	if maxAvailable == 0 {
+		return map[string]*pluginapi.ListOfTopologyHints{
+			string(v1.ResourceCPU): nil, // indicates that there is no numa preference
+		}
+	}
+
+	hints := map[string]*pluginapi.ListOfTopologyHints{
+		string(v1.ResourceCPU): {
+			Hints: make([]*pluginapi.TopologyHint, 0, len(numaNodes)),
+		},
+	}
+	for _, numaNode := range numaNodes {
+		hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
+			Nodes:     []uint64{uint64(numaNode)},
+			Preferred: numaAvailable[numaNode] == maxAvailable,
+		})
+	}
+	return hints
 }
 
 func (p *DynamicPolicy) dedicatedCoresHintHandler(ctx context.Context,
@@ -67,29 +187,46 @@ func (p *DynamicPolicy) dedicatedCoresHintHandler(ctx context.Context,
 
 func (p *DynamicPolicy) dedicatedCoresWithNUMABindingHintHandler(_ context.Context,
 	req *pluginapi.ResourceRequest) (*pluginapi.ResourceHintsResponse, error) {
+	if p.numaBindingUnsupportedErr != nil {
+		return nil, fmt.Errorf("NUMA binding isn't supported on this machine: %v, please remove numa_binding from the pod",
+			p.numaBindingUnsupportedErr)
+	}
+
+	reqInt, err := util.GetQuantityFromResourceReq(req)
+	if err != nil {
+		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+	}
+	logger := getHintLogger(req, reqInt)
+
+	// annotations are the same for all containers of the pod, so the main container is rejected here as well
+	if err := checkSidecarOwnCPUsSupported(req.Annotations); err != nil {
+		return nil, err
+	}
+
 	// currently, we set cpuset of sidecar to the cpuset of its main container,
-	// so there is no numa preference here.
+	// so there is no numa preference here, unless the sidecar opts into its own cpus.
 	if req.ContainerType == pluginapi.ContainerType_SIDECAR {
+		if checkSidecarOwnCPUs(req.Annotations) {
+			return p.dedicatedCoresWithNUMABindingSidecarOwnCPUsHintHandler(req, reqInt)
+		}
+
 		return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
 			map[string]*pluginapi.ListOfTopologyHints{
 				string(v1.ResourceCPU): nil, // indicates that there is no numa preference
 			})
 	}
 
-	reqInt, err := util.GetQuantityFromResourceReq(req)
-	if err != nil {
-		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
-	}
-
 	machineState := p.state.GetMachineState()
 	var hints map[string]*pluginapi.ListOfTopologyHints
 
 	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
 	if allocationInfo != nil {
-		hints = cpuutil.RegenerateHints(allocationInfo, reqInt)
+		hints = p.regenerateHints(allocationInfo, reqInt)
 
 		// regenerateHints failed. need to clear container record and re-calculate.
 		if hints == nil {
+			p.recordAllocationDiscarded(req, allocationInfo, reqInt)
+
 			podEntries := p.state.GetPodEntries()
 			delete(podEntries[req.PodUid], req.ContainerName)
 			if len(podEntries[req.PodUid]) == 0 {
@@ -99,128 +236,941 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingHintHandler(_ context.Conte
 			var err error
 			machineState, err = generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
 			if err != nil {
-				general.Errorf("pod: %s/%s, container: %s GenerateMachineStateFromPodEntries failed with error: %v",
-					req.PodNamespace, req.PodName, req.ContainerName, err)
+				logger.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 				return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
 			}
 		}
 	}
 
-	// if hints exists in extra state-file, prefer to use them
+	trace := p.newHintTrace(req, reqInt, machineState)
+	if hints != nil {
+		trace.setSource(hintTraceSourceAllocated)
+	}
+
 	if hints == nil {
-		availableNUMAs := machineState.GetFilteredNUMASet(state.CheckNUMABinding)
+		hints, err = p.calculateNUMABindingHints(logger, req, reqInt, machineState, trace, trace.getRejections())
+		if err != nil {
+			return nil, p.wrapHintRejections(logger, req, reqInt, machineState, trace, err)
+		}
+	}
 
-		var extraErr error
-		hints, extraErr = util.GetHintsFromExtraStateFile(req.PodName, string(v1.ResourceCPU), p.extraStateFileAbsPath, availableNUMAs)
+	resp, err := util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
+	if err != nil {
+		return nil, err
+	}
+
+	if hints[string(v1.ResourceCPU)] != nil && len(hints[string(v1.ResourceCPU)].Hints) == 0 {
+		p.reportHintRejections(logger, req, reqInt, machineState, trace, resp)
+	}
+
+	if p.enableReclaimedDisplacementReport {
+		p.reportReclaimedDisplacement(req, reqInt, hints[string(v1.ResourceCPU)].GetHints(), resp)
+	}
+
+	trace.report(logger, hints[string(v1.ResourceCPU)].GetHints(), resp)
+	return resp, nil
+}
+
+// calculateNUMABindingHints returns hints for dedicated_cores with NUMA binding without allocated cpus
+// in the given machine state: hints in extra state-file are preferred if any, otherwise hints are calculated,
+// then adjusted by NUMA hint of scheduler and coordinated NUMA. it doesn't mutate state, so it's shared
+// by the hint handler and SimulateHints. the given trace records where hints come from, and reasons of
+// rejected NUMA masks are recorded into the given rejections, both of them may be nil.
+func (p *DynamicPolicy) calculateNUMABindingHints(logger general.Logger, req *pluginapi.ResourceRequest, reqInt int,
+	machineState state.NUMANodeMap, trace *hintTrace, rejections hintRejections) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	// if hints exists in extra state-file, prefer to use them
+	if p.extraStateFileCache != nil {
+		availableNUMAs := machineState.GetFilteredNUMASet(state.CheckNUMABinding)
+		extraHints, extraErr := p.extraStateFileCache.GetHintsFromExtraStateFile(req.PodName, string(v1.ResourceCPU), availableNUMAs)
 		if extraErr != nil {
-			general.Infof("pod: %s/%s, container: %s GetHintsFromExtraStateFile failed with error: %v",
-				req.PodNamespace, req.PodName, req.ContainerName, extraErr)
+			logger.Infof("GetHintsFromExtraStateFile failed with error: %v", extraErr)
+		} else if extraHints != nil {
+			trace.setSource(hintTraceSourceExtraStateFile)
+			return extraHints, nil
 		}
 	}
 
-	// otherwise, calculate hint for container without allocated memory
+	// otherwise, calculate hint for container without allocated cpus
+	trace.setSource(hintTraceSourceCalculated)
+	hints, err := p.calculateHintsWithRejections(logger, reqInt, req.PodNamespace, machineState,
+		req.Annotations, rejections, p.getMemoryAvailabilityChecker(logger, req))
+	if err != nil {
+		return nil, fmt.Errorf("calculateHints failed with error: %v", err)
+	}
+
+	p.applyNUMAHint(req, hints[string(v1.ResourceCPU)].Hints)
+
+	hints[string(v1.ResourceCPU)].Hints, err = applyCoordinatedNUMA(logger, req, hints[string(v1.ResourceCPU)].Hints)
+	if err != nil {
+		return nil, err
+	}
+	return hints, nil
+}
+
+// reportHintRejections sets the reason why each NUMA mask is rejected into annotations of hints response,
+// so it should only be called if no hint is left.
+func (p *DynamicPolicy) reportHintRejections(logger general.Logger, req *pluginapi.ResourceRequest, reqInt int,
+	machineState state.NUMANodeMap, trace *hintTrace, resp *pluginapi.ResourceHintsResponse) {
+	rejectionsBytes, err := json.Marshal(p.getHintRejections(logger, req, reqInt, machineState, trace))
+	if err != nil {
+		logger.Errorf("marshal hint rejections failed with error: %v", err)
+		return
+	}
+
+	if resp.Annotations == nil {
+		resp.Annotations = make(map[string]string)
+	}
+	resp.Annotations[cpuconsts.ResourceHintsAnnotationHintRejectionsKey] = string(rejectionsBytes)
+}
+
+// wrapHintRejections returns the error of hints calculation along with the reason why each NUMA mask
+// is rejected, since there is no hints response to carry them.
+func (p *DynamicPolicy) wrapHintRejections(logger general.Logger, req *pluginapi.ResourceRequest, reqInt int,
+	machineState state.NUMANodeMap, trace *hintTrace, calculateErr error) error {
+	rejectionsBytes, err := json.Marshal(p.getHintRejections(logger, req, reqInt, machineState, trace))
+	if err != nil {
+		logger.Errorf("marshal hint rejections failed with error: %v", err)
+		return calculateErr
+	}
+	return fmt.Errorf("%v, rejections: %s", calculateErr, string(rejectionsBytes))
+}
+
+// getHintRejections returns rejections recorded by the trace if the request is traced, otherwise they're
+// collected by calculating candidate hints once more, so that the happy path doesn't pay for them.
+// the calculation here doesn't emit metrics of hints calculation, and it's only for reasons of rejection.
+func (p *DynamicPolicy) getHintRejections(logger general.Logger, req *pluginapi.ResourceRequest, reqInt int,
+	machineState state.NUMANodeMap, trace *hintTrace) hintRejections {
+	if rejections := trace.getRejections(); rejections != nil {
+		return rejections
+	}
+
+	rejections := make(hintRejections)
+	numaPerSocket, err := p.machineInfo.NUMAsPerSocket()
+	if err != nil {
+		logger.Errorf("NUMAsPerSocket failed with error: %v", err)
+		return rejections
+	}
+
+	candidateHints, _, roundedReqInt, err := calculateHintsByTopology(logger, reqInt, machineState, p.machineInfo.CPUTopology,
+		p.getHintReservedCPUs(), numaPerSocket, p.crossSocketPolicy, req.Annotations, rejections, p.getHintPreferenceStrategy())
+	if err != nil {
+		return rejections
+	}
+
+	// the error of NUMA budget is expected here, and masks exceeding the budget have been recorded
+	_, _ = p.filterCandidateHints(logger, roundedReqInt, req.PodNamespace, candidateHints, machineState,
+		req.Annotations, rejections, nil)
+	return rejections
+}
+
+// reportReclaimedDisplacement sets the count of reclaimed cpus displaced by each hint into annotations
+// of hints response. numa_exclusive container displaces all reclaimed cpus in its NUMA nodes, otherwise
+// reclaimed cpus are assumed to be displaced first, since reclaimed pool only uses the left cpus.
+func (p *DynamicPolicy) reportReclaimedDisplacement(req *pluginapi.ResourceRequest, reqInt int,
+	hints []*pluginapi.TopologyHint, resp *pluginapi.ResourceHintsResponse) {
+	if len(hints) == 0 {
+		return
+	}
+
+	// pools aren't recorded in pod entries of NUMA node states, so get reclaimed cpus from the pool entry
+	reclaimedAllocationInfo := p.state.GetAllocationInfo(state.PoolNameReclaim, advisorapi.FakedContainerName)
+	if reclaimedAllocationInfo == nil {
+		return
+	}
+
+	displacement := make(map[string]int, len(hints))
+	for _, hint := range hints {
+		reclaimedCPUs := 0
+		for _, numaID := range util.HintToIntArray(hint) {
+			reclaimedCPUs += reclaimedAllocationInfo.TopologyAwareAssignments[numaID].Size()
+		}
+
+		if !qosutil.AnnotationsIndicateNUMAExclusive(req.Annotations) && reclaimedCPUs > reqInt {
+			reclaimedCPUs = reqInt
+		}
+		displacement[machine.NewCPUSet(util.HintToIntArray(hint)...).String()] = reclaimedCPUs
+	}
+
+	displacementBytes, err := json.Marshal(displacement)
+	if err != nil {
+		general.Errorf("pod: %s/%s, container: %s marshal reclaimed displacement failed with error: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, err)
+		return
+	}
+
+	if resp.Annotations == nil {
+		resp.Annotations = make(map[string]string)
+	}
+	resp.Annotations[cpuconsts.ResourceHintsAnnotationReclaimedDisplacementKey] = string(displacementBytes)
+}
+
+// dedicatedCoresWithNUMABindingSidecarOwnCPUsHintHandler returns the hint constrained to NUMA nodes
+// of the main container for sidecar opting into its own cpus, and fails if those NUMA nodes
+// don't have enough available cpus; if the main container isn't allocated yet, there is no numa preference.
+func (p *DynamicPolicy) dedicatedCoresWithNUMABindingSidecarOwnCPUsHintHandler(req *pluginapi.ResourceRequest,
+	reqInt int) (*pluginapi.ResourceHintsResponse, error) {
+	mainContainerAllocationInfo := p.state.GetPodEntries()[req.PodUid].GetMainContainerEntry()
+	if mainContainerAllocationInfo == nil {
+		general.Infof("main container is not found for pod: %s/%s, sidecar: %s, there is no numa preference",
+			req.PodNamespace, req.PodName, req.ContainerName)
+		return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
+			map[string]*pluginapi.ListOfTopologyHints{
+				string(v1.ResourceCPU): nil, // indicates that there is no numa preference
+			})
+	}
+
+	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	if allocationInfo != nil {
+		if hints := p.regenerateHints(allocationInfo, reqInt); hints != nil {
+			return util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
+		}
+	}
+
+	machineState := p.state.GetMachineState()
+	numaNodes := make([]int, 0, len(mainContainerAllocationInfo.TopologyAwareAssignments))
+	availableCPUs := machine.NewCPUSet()
+	for numaNode, cset := range mainContainerAllocationInfo.TopologyAwareAssignments {
+		if cset.Size() == 0 {
+			continue
+		}
+
+		numaNodes = append(numaNodes, numaNode)
+		availableCPUs = availableCPUs.Union(machineState[numaNode].GetAvailableCPUSet(p.getNUMAReservedCPUs(numaNode)))
+		// cpus of the existing allocation will be released before the sidecar is re-allocated
+		if allocationInfo != nil && checkSidecarOwnCPUs(allocationInfo.Annotations) {
+			availableCPUs = availableCPUs.Union(allocationInfo.TopologyAwareAssignments[numaNode])
+		}
+	}
+	sort.Ints(numaNodes)
+
+	if availableCPUs.Size() < reqInt {
+		return nil, fmt.Errorf("available cpus: %d in NUMAs: %v of main container is smaller than sidecar request: %d",
+			availableCPUs.Size(), numaNodes, reqInt)
+	}
+
+	nodes := make([]uint64, 0, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		nodes = append(nodes, uint64(numaNode))
+	}
+	return util.PackResourceHintsResponse(req, string(v1.ResourceCPU),
+		map[string]*pluginapi.ListOfTopologyHints{
+			string(v1.ResourceCPU): {
+				Hints: []*pluginapi.TopologyHint{
+					{
+						Nodes:     nodes,
+						Preferred: true,
+					},
+				},
+			},
+		})
+}
+
+// getMemoryAvailabilityChecker returns the function checking whether memory of the container can be satisfied
+// by the given NUMA nodes according to memory resource plugin, and calculateHints downgrades preferred hints
+// failing the check, so that topology manager won't pick a NUMA intersection that's preferred by cpu but not
+// by memory; nil is returned if memory resource plugin isn't registered, and hints are kept as they are.
+func (p *DynamicPolicy) getMemoryAvailabilityChecker(logger general.Logger,
+	req *pluginapi.ResourceRequest) func(maskBits []int) bool {
+	if p.getMemoryNUMAAvailabilityProvider == nil {
+		return nil
+	}
+
+	provider := p.getMemoryNUMAAvailabilityProvider()
+	if provider == nil {
+		return nil
+	}
+
+	var reqBytes uint64
+	if p.metaServer != nil {
+		container, err := p.metaServer.GetContainerSpec(req.PodUid, req.ContainerName)
+		if err != nil || container == nil {
+			logger.Warningf("get container spec failed with error: %v, align hints with memory regardless of request", err)
+		} else {
+			memoryQuantity := native.MemoryQuantityGetter()(container.Resources.Requests)
+			reqBytes = uint64(general.Max(int(memoryQuantity.Value()), 0))
+		}
+	}
+
+	return func(maskBits []int) bool {
+		if provider.MemoryAvailableInNUMAs(maskBits, reqBytes, req.Annotations) {
+			return true
+		}
+
+		logger.Infof("memory of NUMAs: %v can't satisfy request bytes: %d, downgrade to non-preferred", maskBits, reqBytes)
+		return false
+	}
+}
+
+// applyNUMAHint keeps preferred only for the hint matching NUMA nodes chosen by scheduler if any,
+// so that agent agrees with scheduler; if the chosen NUMA nodes are infeasible locally,
+// the calculated hints are kept as they are.
+func (p *DynamicPolicy) applyNUMAHint(req *pluginapi.ResourceRequest, hints []*pluginapi.TopologyHint) {
+	numaHint, ok := req.Annotations[cpuconsts.PodAnnotationNUMAHintKey]
+	if !ok {
+		return
+	}
+
+	numaNodes, err := machine.Parse(numaHint)
+	if err != nil || numaNodes.IsEmpty() {
+		general.Errorf("pod: %s/%s, container: %s has invalid NUMA hint: %s, err: %v",
+			req.PodNamespace, req.PodName, req.ContainerName, numaHint, err)
+		_ = p.emitter.StoreInt64(util.MetricNameNUMAHintMismatch, 1, metrics.MetricTypeNameRaw)
+		return
+	}
+
+	matched := -1
+	for i, hint := range hints {
+		if machine.NewCPUSet(util.HintToIntArray(hint)...).Equals(numaNodes) {
+			matched = i
+			break
+		}
+	}
+
+	if matched < 0 {
+		general.Warningf("pod: %s/%s, container: %s NUMA hint: %s from scheduler is infeasible, use calculated hints",
+			req.PodNamespace, req.PodName, req.ContainerName, numaHint)
+		_ = p.emitter.StoreInt64(util.MetricNameNUMAHintMismatch, 1, metrics.MetricTypeNameRaw)
+		return
+	}
+
+	for i, hint := range hints {
+		hint.Preferred = i == matched
+	}
+	util.SortTopologyHints(hints)
+}
+
+// applyCoordinatedNUMA returns only the hint matching NUMA nodes chosen by external coordinator if any,
+// as a strong preference for cooperative placement; different from applyNUMAHint, the request is rejected
+// if the chosen NUMA nodes are infeasible locally, since the coordinator relies on the exact placement.
+func applyCoordinatedNUMA(logger general.Logger, req *pluginapi.ResourceRequest,
+	hints []*pluginapi.TopologyHint) ([]*pluginapi.TopologyHint, error) {
+	coordinatedNUMA, ok := req.Annotations[cpuconsts.PodAnnotationCoordinatedNUMAKey]
+	if !ok {
+		return hints, nil
+	}
+
+	numaNodes, err := machine.Parse(coordinatedNUMA)
+	if err != nil || numaNodes.IsEmpty() {
+		return nil, fmt.Errorf("invalid coordinated NUMA: %s, err: %v", coordinatedNUMA, err)
+	}
+
+	for _, hint := range hints {
+		if machine.NewCPUSet(util.HintToIntArray(hint)...).Equals(numaNodes) {
+			logger.Infof("use hint: %v matching coordinated NUMA: %s", hint.Nodes, coordinatedNUMA)
+			return []*pluginapi.TopologyHint{
+				{
+					Nodes:     hint.Nodes,
+					Preferred: true,
+				},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("coordinated NUMA: %s is infeasible", coordinatedNUMA)
+}
+
+// regenerateHints returns hints of the existing allocation just like cpuutil.RegenerateHints, except that
+// nil is returned if the allocation overlaps current reserved cpus (e.g. reserved cpus grew after restart),
+// so that the allocation will be re-calculated to make reserved cpus take effect.
+func (p *DynamicPolicy) regenerateHints(allocationInfo *state.AllocationInfo, reqInt int) map[string]*pluginapi.ListOfTopologyHints {
+	if overlapped := allocationInfo.AllocationResult.Intersection(p.reservedCPUs); !overlapped.IsEmpty() {
+		general.Infof("pod: %s/%s, container: %s allocation: %s overlaps reserved cpus: %s, skip regenerating hints",
+			allocationInfo.PodNamespace, allocationInfo.PodName, allocationInfo.ContainerName,
+			allocationInfo.AllocationResult.String(), overlapped.String())
+		return nil
+	}
+	return cpuutil.RegenerateHints(allocationInfo, reqInt)
+}
+
+// recordAllocationDiscarded emits a metric and an event for the container whose allocation
+// is discarded since hints can't be regenerated from it (e.g. the container is resized),
+// which helps to correlate flapping allocations with resize operations.
+func (p *DynamicPolicy) recordAllocationDiscarded(req *pluginapi.ResourceRequest,
+	allocationInfo *state.AllocationInfo, reqInt int) {
+	general.Infof("pod: %s/%s, container: %s discards allocation: %s, original allocated quantity: %d, new requested quantity: %d",
+		req.PodNamespace, req.PodName, req.ContainerName, allocationInfo.AllocationResult.String(),
+		allocationInfo.AllocationResult.Size(), reqInt)
+
+	_ = p.emitter.StoreInt64(util.MetricNameAllocationDiscarded, 1, metrics.MetricTypeNameRaw,
+		metrics.MetricTag{Key: "podNamespace", Val: req.PodNamespace},
+		metrics.MetricTag{Key: "podName", Val: req.PodName},
+		metrics.MetricTag{Key: "containerName", Val: req.ContainerName})
+
+	if p.recorder == nil {
+		return
+	}
+
+	p.recorder.Eventf(&v1.ObjectReference{
+		Kind:      "Pod",
+		Namespace: req.PodNamespace,
+		Name:      req.PodName,
+		UID:       types.UID(req.PodUid),
+	}, nil, v1.EventTypeWarning, cpuconsts.EventReasonAllocationDiscarded, cpuconsts.EventActionRegeneratingHints,
+		"container %s discards allocated cpus %s since hints can't be regenerated for requested quantity %d",
+		req.ContainerName, allocationInfo.AllocationResult.String(), reqInt)
+}
+
+// dedicatedCoresWithoutNUMABindingHintHandler returns hints of the existing allocation if any,
+// otherwise there is no numa preference since exclusive cpus can span NUMA nodes, and
+// calculateHints isn't involved because it only works for NUMA-binding containers.
+func (p *DynamicPolicy) dedicatedCoresWithoutNUMABindingHintHandler(_ context.Context,
+	req *pluginapi.ResourceRequest) (*pluginapi.ResourceHintsResponse, error) {
+	reqInt, err := util.GetQuantityFromResourceReq(req)
+	if err != nil {
+		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+	}
+
+	var hints map[string]*pluginapi.ListOfTopologyHints
+
+	// only regenerate hints for isolated containers, since containers in fallback pool haven't got exclusive cpus
+	allocationInfo := p.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	if allocationInfo != nil && state.CheckDedicatedPool(allocationInfo) {
+		hints = cpuutil.RegenerateHints(allocationInfo, reqInt)
+	}
+
 	if hints == nil {
-		var calculateErr error
-		// calculate hint for container without allocated cpus
-		hints, calculateErr = p.calculateHints(reqInt, machineState, req.Annotations)
-		if calculateErr != nil {
-			return nil, fmt.Errorf("calculateHints failed with error: %v", calculateErr)
+		hints = map[string]*pluginapi.ListOfTopologyHints{
+			string(v1.ResourceCPU): nil, // indicates that there is no numa preference
 		}
 	}
 
 	return util.PackResourceHintsResponse(req, string(v1.ResourceCPU), hints)
 }
 
-func (p *DynamicPolicy) dedicatedCoresWithoutNUMABindingHintHandler(_ context.Context,
-	_ *pluginapi.ResourceRequest) (*pluginapi.ResourceHintsResponse, error) {
-	// todo: support dedicated_cores without NUMA binding
-	return nil, fmt.Errorf("not support dedicated_cores without NUMA binding")
+// MinNUMAsForRequest returns the minimal count of NUMA nodes needed by the given cpu request
+// on this node's topology, counting NUMA nodes with the most cpus first if they have different cpu capacity;
+// it equals to minNUMAsCountNeeded in calculateHints, which decides the preferred hints.
+func (p *DynamicPolicy) MinNUMAsForRequest(reqInt int) (int, error) {
+	minNUMAsCountNeeded, _, err := util.GetNUMANodesCountToFitCPUReq(reqInt, p.machineInfo.CPUTopology)
+	if err != nil {
+		return 0, fmt.Errorf("GetNUMANodesCountToFitCPUReq failed with error: %v", err)
+	}
+	return minNUMAsCountNeeded, nil
+}
+
+// MinNUMAsForRequestWithReserved is the reserved-aware variant of MinNUMAsForRequest,
+// it may be larger than minNUMAsCountNeeded in calculateHints if reserved cpus
+// make NUMA nodes unable to hold the request.
+func (p *DynamicPolicy) MinNUMAsForRequestWithReserved(reqInt int) (int, error) {
+	minNUMAsCountNeeded, err := util.GetNUMANodesCountToFitCPUReqWithReserved(reqInt, p.machineInfo.CPUTopology, p.reservedCPUs)
+	if err != nil {
+		return 0, fmt.Errorf("GetNUMANodesCountToFitCPUReqWithReserved failed with error: %v", err)
+	}
+	return minNUMAsCountNeeded, nil
+}
+
+// GetNUMAAvailability returns count of cpus available for dedicated_cores with NUMA binding in each NUMA,
+// i.e. cpus excluding reserved and allocated ones, which are the same as what calculateHints sees.
+func (p *DynamicPolicy) GetNUMAAvailability() map[int]int {
+	p.RLock()
+	machineState := p.state.GetMachineState()
+	p.RUnlock()
+
+	reservedCPUs := p.getHintReservedCPUs()
+	availability := make(map[int]int, len(machineState))
+	for numaID, numaState := range machineState {
+		if numaState == nil {
+			continue
+		}
+		availability[numaID] = numaState.GetAvailableCPUSet(reservedCPUs).Size()
+	}
+	return availability
+}
+
+// getHintReservedCPUs returns reserved cpus of all NUMA nodes, which are excluded from available cpus in hints
+func (p *DynamicPolicy) getHintReservedCPUs() machine.CPUSet {
+	reservedCPUs := machine.NewCPUSet()
+	for _, numaID := range p.machineInfo.CPUDetails.NUMANodes().ToSliceInt() {
+		reservedCPUs = reservedCPUs.Union(p.getNUMAReservedCPUs(numaID).Intersection(p.machineInfo.CPUDetails.CPUsInNUMANodes(numaID)))
+	}
+	return reservedCPUs
 }
 
 // calculateHints is a helper function to calculate the topology hints
-// with the given container requests.
-func (p *DynamicPolicy) calculateHints(reqInt int, machineState state.NUMANodeMap,
+// with the given container requests, and the returned hints are sorted by util.SortTopologyHints,
+// then preferred hints of the same size are reordered by the hint preference strategy.
+// masks that push the pod namespace over its NUMA budget are excluded.
+func (p *DynamicPolicy) calculateHints(reqInt int, podNamespace string, machineState state.NUMANodeMap,
 	reqAnnotations map[string]string) (map[string]*pluginapi.ListOfTopologyHints, error) {
-	numaNodes := make([]int, 0, len(machineState))
-	for numaNode := range machineState {
-		numaNodes = append(numaNodes, numaNode)
+	return p.calculateHintsWithLogger(general.LoggerWithPrefix(fmt.Sprintf("podNamespace=%s requestedCPU=%d",
+		podNamespace, reqInt), general.LoggingPKGFull), reqInt, podNamespace, machineState, reqAnnotations)
+}
+
+// calculateHintsWithLogger is the same as calculateHints, except that all logs are attributed to the given logger
+func (p *DynamicPolicy) calculateHintsWithLogger(logger general.Logger, reqInt int, podNamespace string,
+	machineState state.NUMANodeMap, reqAnnotations map[string]string) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	return p.calculateHintsWithRejections(logger, reqInt, podNamespace, machineState, reqAnnotations, nil, nil)
+}
+
+// calculateHintsWithRejections is the same as calculateHintsWithLogger, and it also records
+// why each NUMA mask is rejected into the given rejections if it's not nil. if memoryAvailable
+// isn't nil, preferred hints whose NUMA nodes can't satisfy memory are downgraded before sorting.
+func (p *DynamicPolicy) calculateHintsWithRejections(logger general.Logger, reqInt int, podNamespace string,
+	machineState state.NUMANodeMap, reqAnnotations map[string]string, rejections hintRejections,
+	memoryAvailable func(maskBits []int) bool) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	calculationStart := time.Now()
+	defer p.emitHintsCalculationDuration(hintsCalculationPhaseTotal, calculationStart)
+
+	numaPerSocket, err := p.machineInfo.NUMAsPerSocket()
+	if err != nil {
+		return nil, fmt.Errorf("NUMAsPerSocket failed with error: %v", err)
 	}
-	sort.Ints(numaNodes)
 
+	reservedCPUs := p.getHintReservedCPUs()
+	preference := p.getHintPreferenceStrategy()
+	candidateHints, minNUMAsCountNeeded, reqInt, err := calculateHintsByTopology(logger, reqInt, machineState,
+		p.machineInfo.CPUTopology, reservedCPUs, numaPerSocket, p.crossSocketPolicy, reqAnnotations, rejections, preference)
+	p.emitHintsCalculationDuration(hintsCalculationPhaseTopology, calculationStart)
+	if err != nil {
+		return nil, err
+	}
+	filterStart := time.Now()
+
+	cpuHints, err := p.filterCandidateHints(logger, reqInt, podNamespace, candidateHints, machineState,
+		reqAnnotations, rejections, memoryAvailable)
+	if err != nil {
+		return nil, err
+	}
 	hints := map[string]*pluginapi.ListOfTopologyHints{
 		string(v1.ResourceCPU): {
-			Hints: []*pluginapi.TopologyHint{},
+			Hints: cpuHints,
 		},
 	}
+	p.emitHintsCalculationDuration(hintsCalculationPhaseFilter, filterStart)
 
-	minNUMAsCountNeeded, _, err := util.GetNUMANodesCountToFitCPUReq(reqInt, p.machineInfo.CPUTopology)
+	sortStart := time.Now()
+	// NUMA distances are only compared among hints left preferred after filtering,
+	// otherwise the filtered nearest one may leave no preferred hint of its size.
+	applyNUMADistancePreference(hints[string(v1.ResourceCPU)].Hints, p.machineInfo.CPUTopology)
+	p.applySocketSelectionStrategy(hints[string(v1.ResourceCPU)].Hints, machineState)
+	// hints order is part of the contract with topology manager, so always return them as: preferred first,
+	// then fewer NUMAs, then ascending NUMA ids, except that preferred hints of the same size are ordered
+	// by the configured hint preference strategy.
+	util.SortTopologyHints(hints[string(v1.ResourceCPU)].Hints)
+	applyHintPreferenceOrdering(hints[string(v1.ResourceCPU)].Hints, preference, minNUMAsCountNeeded, machineState, reservedCPUs)
+	p.emitHintsCalculationDuration(hintsCalculationPhaseSort, sortStart)
+
+	// if there is no preferred hint, topology manager may reject the pod,
+	// so promote the best one (the first one after sorting) to force placement if needed.
+	cpuHints = hints[string(v1.ResourceCPU)].Hints
+	if p.enableNonPreferredHintPromotion && len(cpuHints) > 0 && !cpuHints[0].Preferred {
+		logger.Infof("no preferred hint exists, promote hint: %v to preferred", cpuHints[0].Nodes)
+		cpuHints[0].Preferred = true
+	}
+
+	return hints, nil
+}
+
+// filterCandidateHints filters candidate hints calculated by topology with policy-level constraints,
+// e.g. NUMA budget of the pod namespace and burst reserved NUMAs, and downgrades those that exhaust
+// memory bandwidth or can't satisfy memory to non-preferred; the order of candidate hints is kept.
+// reqInt should be the request returned by getNUMAsCountNeededForHints.
+func (p *DynamicPolicy) filterCandidateHints(logger general.Logger, reqInt int, podNamespace string,
+	candidateHints []*pluginapi.TopologyHint, machineState state.NUMANodeMap, reqAnnotations map[string]string,
+	rejections hintRejections, memoryAvailable func(maskBits []int) bool) ([]*pluginapi.TopologyHint, error) {
+	reqMemoryBandwidth := state.GetMemoryBandwidthFromAnnotations(reqAnnotations)
+	numaBudget, budgetLimited := p.namespaceNUMABudget[podNamespace]
+	namespaceNUMAs := getNamespaceNUMAUsage(machineState)[podNamespace]
+	budgetBlocked := false
+	burstReservedNUMAs := p.getBurstReservedNUMAs(machineState)
+	// hints using burst reserved NUMAs are only returned if no other hint exists
+	burstReservedHints := make([]*pluginapi.TopologyHint, 0)
+
+	hints := make([]*pluginapi.TopologyHint, 0, len(candidateHints))
+
+	for _, hint := range candidateHints {
+		maskBits := util.HintToIntArray(hint)
+
+		if p.enableStrictNUMAExclusiveHints && qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) && len(maskBits) > 1 &&
+			!p.checkStrictNUMAExclusiveMask(reqInt, maskBits, machineState) {
+			logger.InfofV(4, "numa_exclusive container skip NUMAs: %v which isn't fully used by request: %d",
+				maskBits, reqInt)
+			rejections.record(maskBits, cpuconsts.HintRejectionReasonStrictExclusive)
+			continue
+		}
+
+		if budgetLimited && namespaceNUMAs.Union(machine.NewCPUSet(maskBits...)).Size() > numaBudget {
+			logger.InfofV(4, "NUMAs: %v exceed NUMA budget: %d of namespace: %s with occupied NUMAs: %s",
+				maskBits, numaBudget, podNamespace, namespaceNUMAs.String())
+			budgetBlocked = true
+			rejections.record(maskBits, cpuconsts.HintRejectionReasonNamespaceNUMABudget)
+			continue
+		}
+
+		if hint.Preferred && p.checkMemoryBandwidthExhausted(reqMemoryBandwidth, maskBits, machineState) {
+			logger.InfofV(4, "NUMAs: %v exhaust memory bandwidth budget: %d with request: %d, downgrade to non-preferred",
+				maskBits, p.numaMemoryBandwidthBudget, reqMemoryBandwidth)
+			hint.Preferred = false
+		}
+
+		if hint.Preferred && memoryAvailable != nil && !memoryAvailable(maskBits) {
+			hint.Preferred = false
+		}
+
+		if burstReservedNUMAs.Intersection(machine.NewCPUSet(maskBits...)).Size() > 0 {
+			burstReservedHints = append(burstReservedHints, hint)
+			continue
+		}
+
+		hints = append(hints, hint)
+	}
+
+	if len(hints) == 0 && len(burstReservedHints) > 0 {
+		logger.Infof("no hint exists without burst reserved NUMAs: %s, fall back to use them", burstReservedNUMAs.String())
+		hints = burstReservedHints
+	}
+
+	if budgetBlocked && len(hints) == 0 {
+		return nil, fmt.Errorf("namespace: %s already occupies NUMAs: %s, no hint fits into its NUMA budget: %d",
+			podNamespace, namespaceNUMAs.String(), numaBudget)
+	}
+	return hints, nil
+}
+
+// emitHintsCalculationDuration emits the duration (in microseconds) of the given phase of hints calculation
+// since the given start, labelled by count of NUMA nodes, since the cost grows with the count of NUMA masks.
+func (p *DynamicPolicy) emitHintsCalculationDuration(phase string, start time.Time) {
+	_ = p.emitter.StoreInt64(util.MetricNameHintsCalculationDuration, time.Since(start).Microseconds(), metrics.MetricTypeNameRaw,
+		metrics.MetricTag{Key: "phase", Val: phase},
+		metrics.MetricTag{Key: "numaCount", Val: strconv.Itoa(p.machineInfo.NumNUMANodes)})
+}
+
+// getNUMAsCountNeededForHints returns the minimal count of NUMA nodes needed by the request,
+// along with the request actually used to calculate hints. for containers only wanting
+// full physical cores, the request is rounded up to whole cores.
+func getNUMAsCountNeededForHints(reqInt int, topology *machine.CPUTopology,
+	reqAnnotations map[string]string) (int, int, error) {
+	if reqAnnotations[cpuconsts.PodAnnotationFullPCPUsOnlyKey] == cpuconsts.PodAnnotationFullPCPUsOnlyEnable {
+		minNUMAsCountNeeded, roundedReqInt, err := util.GetNUMANodesCountToFitFullPCPUsReq(reqInt, topology)
+		if err != nil {
+			return 0, 0, fmt.Errorf("GetNUMANodesCountToFitFullPCPUsReq failed with error: %v", err)
+		}
+		return minNUMAsCountNeeded, roundedReqInt, nil
+	}
+
+	minNUMAsCountNeeded, _, err := util.GetNUMANodesCountToFitCPUReq(reqInt, topology)
 	if err != nil {
-		return nil, fmt.Errorf("GetNUMANodesCountToFitCPUReq failed with error: %v", err)
+		return 0, 0, fmt.Errorf("GetNUMANodesCountToFitCPUReq failed with error: %v", err)
+	}
+	return minNUMAsCountNeeded, reqInt, nil
+}
+
+// calculateHintsByTopology calculates candidate hints only by machine state and topology,
+// without any policy-level adjustment (e.g. NUMA budget, socket selection or sorting).
+// the returned hints are in the order of bitmask.IterateBitMasks, and a hint is preferred
+// if it's preferred by the given strategy (min_numas if nil). masks crossing sockets are rejected
+// according to crossSocketPolicy (prefer_same_socket if empty). it also returns the minimal count of
+// NUMA nodes needed and the request actually used, as returned by getNUMAsCountNeededForHints.
+func calculateHintsByTopology(logger general.Logger, reqInt int, machineState state.NUMANodeMap,
+	topology *machine.CPUTopology, reservedCPUs machine.CPUSet, numaPerSocket int, crossSocketPolicy string,
+	reqAnnotations map[string]string, rejections hintRejections,
+	preference HintPreferenceStrategy) ([]*pluginapi.TopologyHint, int, int, error) {
+	if topology == nil {
+		return nil, 0, 0, fmt.Errorf("calculateHintsByTopology got nil topology")
+	} else if preference == nil {
+		preference = minNUMAsHintPreferenceStrategy{}
 	}
 
-	// because it's hard to control memory allocation accurately,
-	// we only support numa_binding but not exclusive container with request smaller than 1 NUMA
-	if qosutil.AnnotationsIndicateNUMABinding(reqAnnotations) &&
+	topologyNUMAs := topology.CPUDetails.NUMANodes()
+	numaNodes := make([]int, 0, len(machineState))
+	for numaNode, numaState := range machineState {
+		if !topologyNUMAs.Contains(numaNode) {
+			// NUMA absent from machine topology isn't able to be allocated anyway
+			logger.Warningf("NUMA: %d in machine state doesn't exist in topology", numaNode)
+			continue
+		} else if numaState == nil {
+			// NUMA present in topology but nil in state indicates that state is corrupted,
+			// return error instead of dropping masks containing it silently
+			return nil, 0, 0, fmt.Errorf("NUMA: %d has nil state", numaNode)
+		}
+		numaNodes = append(numaNodes, numaNode)
+	}
+	sort.Ints(numaNodes)
+
+	minNUMAsCountNeeded, reqInt, err := getNUMAsCountNeededForHints(reqInt, topology, reqAnnotations)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	fullPCPUsOnly := reqAnnotations[cpuconsts.PodAnnotationFullPCPUsOnlyKey] == cpuconsts.PodAnnotationFullPCPUsOnlyEnable
+	strictSingleNUMA := reqAnnotations[cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinKey] ==
+		cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinEnable
+
+	var fitErr error
+	if strictSingleNUMA && minNUMAsCountNeeded > 1 {
+		// memory of containers strictly pinned to one NUMA can't float to others,
+		// so the request must fit into one NUMA no matter whether it's exclusive or not
+		fitErr = fmt.Errorf("strict single NUMA memory pin container has request: %d larger than 1 NUMA", reqInt)
+	} else if qosutil.AnnotationsIndicateNUMABinding(reqAnnotations) &&
 		!qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) &&
 		minNUMAsCountNeeded > 1 {
-		return nil, fmt.Errorf("NUMA not exclusive binding container has request larger than 1 NUMA")
+		// because it's hard to control memory allocation accurately,
+		// we only support numa_binding but not exclusive container with request smaller than 1 NUMA
+		fitErr = fmt.Errorf("NUMA not exclusive binding container has request larger than 1 NUMA")
 	}
 
-	numaPerSocket, err := p.machineInfo.NUMAsPerSocket()
-	if err != nil {
-		return nil, fmt.Errorf("NUMAsPerSocket failed with error: %v", err)
+	// all masks are rejected if the request can't fit, so only iterate them if reasons are required
+	if fitErr != nil && rejections == nil {
+		return nil, 0, 0, fitErr
 	}
 
-	bitmask.IterateBitMasks(numaNodes, func(mask bitmask.BitMask) {
-		maskCount := mask.Count()
+	// calculateMaskHint returns the hint consisting of the given NUMA nodes, or nil if they can't be used
+	calculateMaskHint := func(maskBits []int) *pluginapi.TopologyHint {
+		maskCount := len(maskBits)
 		if maskCount < minNUMAsCountNeeded {
-			return
+			rejections.record(maskBits, cpuconsts.HintRejectionReasonInsufficientNUMAs)
+			return nil
+		} else if strictSingleNUMA && maskCount > 1 {
+			rejections.record(maskBits, cpuconsts.HintRejectionReasonSingleNUMAOnly)
+			return nil
 		} else if qosutil.AnnotationsIndicateNUMABinding(reqAnnotations) &&
 			!qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) &&
 			maskCount > 1 {
 			// because it's hard to control memory allocation accurately,
 			// we only support numa_binding but not exclusive container with request smaller than 1 NUMA
-			return
+			rejections.record(maskBits, cpuconsts.HintRejectionReasonSingleNUMAOnly)
+			return nil
 		}
 
-		maskBits := mask.GetBits()
-		numaCountNeeded := mask.Count()
-
 		allAvailableCPUsInMask := machine.NewCPUSet()
 		for _, nodeID := range maskBits {
-			if machineState[nodeID] == nil {
-				general.Warningf("NUMA: %d has nil state", nodeID)
-				return
-			} else if qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) && machineState[nodeID].AllocatedCPUSet.Size() > 0 {
-				general.Warningf("numa_exclusive container skip mask: %s with NUMA: %d allocated: %d",
-					mask.String(), nodeID, machineState[nodeID].AllocatedCPUSet.Size())
-				return
+			if qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) && machineState[nodeID].AllocatedCPUSet.Size() > 0 {
+				logger.Warningf("numa_exclusive container skip mask: %v with NUMA: %d allocated: %d",
+					maskBits, nodeID, machineState[nodeID].AllocatedCPUSet.Size())
+				rejections.record(maskBits, cpuconsts.HintRejectionReasonExclusiveOccupied)
+				return nil
 			}
 
-			allAvailableCPUsInMask = allAvailableCPUsInMask.Union(machineState[nodeID].GetAvailableCPUSet(p.reservedCPUs))
+			allAvailableCPUsInMask = allAvailableCPUsInMask.Union(machineState[nodeID].GetAvailableCPUSet(reservedCPUs))
+		}
+
+		// only cpus of sibling-complete cores are counted as available for containers only wanting full physical cores
+		if fullPCPUsOnly {
+			allAvailableCPUsInMask = util.GetFullPCPUsInCPUSet(topology, allAvailableCPUsInMask)
 		}
 
 		if allAvailableCPUsInMask.Size() < reqInt {
-			general.InfofV(4, "available cpuset: %s of size: %d excluding NUMA binding pods which is smaller than request: %d",
+			logger.InfofV(4, "available cpuset: %s of size: %d excluding NUMA binding pods which is smaller than request: %d",
 				allAvailableCPUsInMask.String(), allAvailableCPUsInMask.Size(), reqInt)
-			return
+			rejections.record(maskBits, cpuconsts.HintRejectionReasonInsufficientCPUs)
+			return nil
 		}
 
-		crossSockets, err := machine.CheckNUMACrossSockets(maskBits, p.machineInfo.CPUTopology)
+		crossSockets, err := machine.CheckNUMACrossSockets(maskBits, topology)
 		if err != nil {
-			general.Errorf("CheckNUMACrossSockets failed with error: %v", err)
-			return
-		} else if numaCountNeeded <= numaPerSocket && crossSockets {
-			general.InfofV(4, "needed: %d; min-needed: %d; NUMAs: %v cross sockets with numaPerSocket: %d",
-				numaCountNeeded, minNUMAsCountNeeded, maskBits, numaPerSocket)
-			return
+			logger.Errorf("CheckNUMACrossSockets failed with error: %v", err)
+			return nil
+		} else if crossSockets && crossSocketPolicy != cpuconsts.CPUCrossSocketPolicyAllowCrossSocket &&
+			(maskCount <= numaPerSocket || crossSocketPolicy == cpuconsts.CPUCrossSocketPolicyForbidCrossSocket) {
+			logger.InfofV(4, "needed: %d; min-needed: %d; NUMAs: %v cross sockets with numaPerSocket: %d and policy: %s",
+				maskCount, minNUMAsCountNeeded, maskBits, numaPerSocket, crossSocketPolicy)
+			rejections.record(maskBits, cpuconsts.HintRejectionReasonCrossSockets)
+			return nil
 		}
 
-		hints[string(v1.ResourceCPU)].Hints = append(hints[string(v1.ResourceCPU)].Hints, &pluginapi.TopologyHint{
-			Nodes:     machine.MaskToUInt64Array(mask),
-			Preferred: len(maskBits) == minNUMAsCountNeeded,
-		})
+		preferred, _ := preference.Preferred(maskBits, minNUMAsCountNeeded, machineState, reservedCPUs)
+		return &pluginapi.TopologyHint{
+			Nodes:     machine.NewCPUSet(maskBits...).ToSliceUInt64(),
+			Preferred: preferred,
+		}
+	}
+
+	hints := make([]*pluginapi.TopologyHint, 0)
+
+	// there is only one possible placement on single NUMA machines, so skip iterating bit masks
+	if topology.NumNUMANodes == 1 && len(numaNodes) == 1 {
+		if hint := calculateMaskHint(numaNodes); hint != nil {
+			hints = append(hints, hint)
+		}
+		if fitErr != nil {
+			return nil, 0, 0, fitErr
+		}
+		return hints, minNUMAsCountNeeded, reqInt, nil
+	}
+
+	bitmask.IterateBitMasks(numaNodes, func(mask bitmask.BitMask) {
+		if hint := calculateMaskHint(mask.GetBits()); hint != nil {
+			hints = append(hints, hint)
+		}
 	})
 
-	return hints, nil
+	if fitErr != nil {
+		return nil, 0, 0, fitErr
+	}
+
+	// if the whole machine is the only placement, e.g. for request of all available cpus, it's the minimal
+	// placement indeed, even though reserved cpus make minNUMAsCountNeeded smaller than the count of NUMA nodes
+	if len(hints) == 1 && len(hints[0].Nodes) == len(numaNodes) && !hints[0].Preferred {
+		logger.Infof("the whole machine: %v is the only placement, make it preferred", numaNodes)
+		hints[0].Preferred = true
+	}
+	return hints, minNUMAsCountNeeded, reqInt, nil
+}
+
+// applyNUMADistancePreference keeps preferred only for multi-NUMA hints with the minimal
+// total inter-NUMA distance among preferred hints of the same size, since NUMA nodes
+// in the same socket may have different distances (e.g. sub-NUMA clustering).
+func applyNUMADistancePreference(hints []*pluginapi.TopologyHint, topology *machine.CPUTopology) {
+	// key by count of NUMA nodes, value is the minimal total distance of preferred hints
+	minDistances := make(map[int]int)
+	for _, hint := range hints {
+		if !hint.Preferred || len(hint.Nodes) < 2 {
+			continue
+		}
+
+		distance := topology.GetNUMAsTotalDistance(util.HintToIntArray(hint))
+		if minDistance, ok := minDistances[len(hint.Nodes)]; !ok || distance < minDistance {
+			minDistances[len(hint.Nodes)] = distance
+		}
+	}
+
+	for _, hint := range hints {
+		if !hint.Preferred || len(hint.Nodes) < 2 {
+			continue
+		}
+
+		if topology.GetNUMAsTotalDistance(util.HintToIntArray(hint)) > minDistances[len(hint.Nodes)] {
+			hint.Preferred = false
+		}
+	}
+}
+
+// hintRejections is a map from NUMA nodes of each rejected mask (in cpuset format) to the rejection reason
+type hintRejections map[string]string
+
+// record is a no-op for nil hintRejections, so that reasons are only collected if required
+func (r hintRejections) record(maskBits []int, reason string) {
+	if r == nil {
+		return
+	}
+	r[machine.NewCPUSet(maskBits...).String()] = reason
+}
+
+// checkMemoryBandwidthExhausted returns true if the requested memory bandwidth, split evenly among
+// NUMA nodes in the mask, exceeds the remaining memory bandwidth budget of any of them.
+func (p *DynamicPolicy) checkMemoryBandwidthExhausted(reqMemoryBandwidth int64, maskBits []int,
+	machineState state.NUMANodeMap) bool {
+	if p.numaMemoryBandwidthBudget <= 0 || reqMemoryBandwidth <= 0 || len(maskBits) == 0 {
+		return false
+	}
+
+	usage := getNUMAMemoryBandwidthUsage(machineState)
+	numaMemoryBandwidth := reqMemoryBandwidth / int64(len(maskBits))
+	for _, nodeID := range maskBits {
+		if usage[nodeID]+numaMemoryBandwidth > p.numaMemoryBandwidthBudget {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStrictNUMAExclusiveMask returns true if all NUMA nodes in the mask are completely free
+// and each of them is needed to satisfy the request, i.e. the request can't fit into the mask
+// without any one of them, so that no NUMA node is left partially used by the exclusive container.
+func (p *DynamicPolicy) checkStrictNUMAExclusiveMask(reqInt int, maskBits []int, machineState state.NUMANodeMap) bool {
+	totalAvailable := 0
+	numaAvailable := make(map[int]int, len(maskBits))
+	for _, nodeID := range maskBits {
+		numaState := machineState[nodeID]
+		if numaState == nil || numaState.AllocatedCPUSet.Size() > 0 {
+			return false
+		}
+
+		numaAvailable[nodeID] = numaState.GetAvailableCPUSet(p.getNUMAReservedCPUs(nodeID)).Size()
+		totalAvailable += numaAvailable[nodeID]
+	}
+
+	for _, nodeID := range maskBits {
+		if totalAvailable-numaAvailable[nodeID] >= reqInt {
+			return false
+		}
+	}
+	return true
+}
+
+// getBurstReservedNUMAs returns NUMA nodes kept unallocated as burst headroom, i.e. burstReservedNUMAsPerSocket
+// NUMA nodes with the least allocated cpus in each socket, and ties are broken by NUMA ids ascending,
+// so the selection is stable for the same machine state.
+func (p *DynamicPolicy) getBurstReservedNUMAs(machineState state.NUMANodeMap) machine.CPUSet {
+	burstReservedNUMAs := machine.NewCPUSet()
+	if p.burstReservedNUMAsPerSocket <= 0 {
+		return burstReservedNUMAs
+	}
+
+	getAllocated := func(numaID int) int {
+		if machineState[numaID] == nil {
+			return 0
+		}
+		return machineState[numaID].AllocatedCPUSet.Size()
+	}
+
+	for _, socketID := range p.machineInfo.CPUDetails.Sockets().ToSliceInt() {
+		numaIDs := p.machineInfo.CPUDetails.NUMANodesInSockets(socketID).ToSliceInt()
+		sort.SliceStable(numaIDs, func(i, j int) bool {
+			return getAllocated(numaIDs[i]) < getAllocated(numaIDs[j])
+		})
+
+		if len(numaIDs) > p.burstReservedNUMAsPerSocket {
+			numaIDs = numaIDs[:p.burstReservedNUMAsPerSocket]
+		}
+		burstReservedNUMAs = burstReservedNUMAs.Union(machine.NewCPUSet(numaIDs...))
+	}
+	return burstReservedNUMAs
+}
+
+// applySocketSelectionStrategy keeps preferred only for single-socket hints on the socket
+// chosen by socketSelectionStrategy, according to cpus allocated in each socket:
+// balance chooses the least-occupied socket, and pack chooses the most-occupied one.
+// if several sockets have the same occupancy, all of them are kept preferred.
+func (p *DynamicPolicy) applySocketSelectionStrategy(hints []*pluginapi.TopologyHint, machineState state.NUMANodeMap) {
+	var pack bool
+	switch p.socketSelectionStrategy {
+	case cpuconsts.CPUSocketSelectionStrategyPack:
+		pack = true
+	case cpuconsts.CPUSocketSelectionStrategyBalance, "":
+	default:
+		general.Warningf("unknown socket selection strategy: %s, ignore it", p.socketSelectionStrategy)
+		return
+	}
+
+	// key by hint index, value is the only socket of its NUMA nodes
+	hintSockets := make(map[int]int)
+	socketOccupancy := make(map[int]int)
+	for i, hint := range hints {
+		if !hint.Preferred {
+			continue
+		}
+
+		sockets := p.machineInfo.CPUDetails.SocketsInNUMANodes(util.HintToIntArray(hint)...)
+		if sockets.Size() != 1 {
+			continue
+		}
+
+		socketID := sockets.ToSliceInt()[0]
+		hintSockets[i] = socketID
+		if _, ok := socketOccupancy[socketID]; ok {
+			continue
+		}
+
+		for _, numaID := range p.machineInfo.CPUDetails.NUMANodesInSockets(socketID).ToSliceInt() {
+			if machineState[numaID] != nil {
+				socketOccupancy[socketID] += machineState[numaID].AllocatedCPUSet.Size()
+			}
+		}
+	}
+
+	if len(socketOccupancy) < 2 {
+		return
+	}
+
+	targetOccupancy := -1
+	for _, occupancy := range socketOccupancy {
+		if targetOccupancy == -1 || (pack && occupancy > targetOccupancy) || (!pack && occupancy < targetOccupancy) {
+			targetOccupancy = occupancy
+		}
+	}
+
+	for i, socketID := range hintSockets {
+		if socketOccupancy[socketID] != targetOccupancy {
+			hints[i].Preferred = false
+		}
+	}
 }
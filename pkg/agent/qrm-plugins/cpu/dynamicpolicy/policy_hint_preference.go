@@ -0,0 +1,184 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+func init() {
+	RegisterHintPreferenceStrategy(cpuconsts.CPUHintPreferenceStrategyMinNUMAs, minNUMAsHintPreferenceStrategy{})
+	RegisterHintPreferenceStrategy(cpuconsts.CPUHintPreferenceStrategyLoadBalancing, loadBalancingHintPreferenceStrategy{})
+	RegisterHintPreferenceStrategy(cpuconsts.CPUNUMAAllocationStrategyBinPacking, availableCPUsHintPreferenceStrategy{binPacking: true})
+	RegisterHintPreferenceStrategy(cpuconsts.CPUNUMAAllocationStrategySpread, availableCPUsHintPreferenceStrategy{binPacking: false})
+}
+
+var hintPreferenceStrategies sync.Map
+
+// HintPreferenceStrategy decides whether each candidate hint is preferred, and how preferred hints
+// consisting of the same count of NUMA nodes are ordered, for dedicated_cores with NUMA binding.
+type HintPreferenceStrategy interface {
+	// Preferred returns whether the hint consisting of the given NUMA nodes is preferred when at least
+	// minNUMAsCountNeeded NUMA nodes are needed by the request, and its ordering key among preferred
+	// hints of the same size, the hint with a smaller key comes first.
+	Preferred(maskBits []int, minNUMAsCountNeeded int, machineState state.NUMANodeMap,
+		reservedCPUs machine.CPUSet) (bool, int)
+}
+
+// RegisterHintPreferenceStrategy registers the strategy with the given name,
+// which can be chosen by --cpu-hint-preference-strategy
+func RegisterHintPreferenceStrategy(name string, strategy HintPreferenceStrategy) {
+	hintPreferenceStrategies.Store(name, strategy)
+}
+
+// GetHintPreferenceStrategy returns the registered strategy with the given name,
+// and empty name means the default one, i.e. min_numas.
+func GetHintPreferenceStrategy(name string) (HintPreferenceStrategy, error) {
+	if name == "" {
+		name = cpuconsts.CPUHintPreferenceStrategyMinNUMAs
+	}
+
+	strategy, ok := hintPreferenceStrategies.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown hint preference strategy: %s", name)
+	}
+	return strategy.(HintPreferenceStrategy), nil
+}
+
+// resolveHintPreferenceStrategy returns the strategy chosen by --cpu-hint-preference-strategy or
+// --cpu-numa-allocation-strategy, since binpacking and spread are registered strategies as well;
+// they only replace the default one, and can't be chosen together with another strategy.
+func resolveHintPreferenceStrategy(hintPreferenceStrategy, numaAllocationStrategy string) (HintPreferenceStrategy, error) {
+	if numaAllocationStrategy == "" {
+		return GetHintPreferenceStrategy(hintPreferenceStrategy)
+	} else if hintPreferenceStrategy != "" && hintPreferenceStrategy != cpuconsts.CPUHintPreferenceStrategyMinNUMAs {
+		return nil, fmt.Errorf("NUMA allocation strategy: %s conflicts with hint preference strategy: %s",
+			numaAllocationStrategy, hintPreferenceStrategy)
+	}
+
+	switch numaAllocationStrategy {
+	case cpuconsts.CPUNUMAAllocationStrategyBinPacking, cpuconsts.CPUNUMAAllocationStrategySpread:
+		return GetHintPreferenceStrategy(numaAllocationStrategy)
+	default:
+		return nil, fmt.Errorf("unknown NUMA allocation strategy: %s", numaAllocationStrategy)
+	}
+}
+
+// getHintPreferenceStrategy returns the strategy of the policy, which falls back to the default one if not set
+func (p *DynamicPolicy) getHintPreferenceStrategy() HintPreferenceStrategy {
+	if p.hintPreferenceStrategy == nil {
+		return minNUMAsHintPreferenceStrategy{}
+	}
+	return p.hintPreferenceStrategy
+}
+
+// minNUMAsHintPreferenceStrategy prefers hints with the minimal count of NUMA nodes needed,
+// and keeps them ordered by NUMA ids.
+type minNUMAsHintPreferenceStrategy struct{}
+
+func (minNUMAsHintPreferenceStrategy) Preferred(maskBits []int, minNUMAsCountNeeded int,
+	_ state.NUMANodeMap, _ machine.CPUSet) (bool, int) {
+	return len(maskBits) == minNUMAsCountNeeded, 0
+}
+
+// loadBalancingHintPreferenceStrategy prefers hints with the minimal count of NUMA nodes needed,
+// and orders them by the ratio of allocated cpus in their NUMA nodes ascending,
+// so that load is balanced among NUMA nodes even if they have different count of cpus.
+type loadBalancingHintPreferenceStrategy struct{}
+
+func (loadBalancingHintPreferenceStrategy) Preferred(maskBits []int, minNUMAsCountNeeded int,
+	machineState state.NUMANodeMap, reservedCPUs machine.CPUSet) (bool, int) {
+	if len(maskBits) != minNUMAsCountNeeded {
+		return false, 0
+	}
+
+	allocated, total := 0, 0
+	for _, numaID := range maskBits {
+		numaState := machineState[numaID]
+		if numaState == nil {
+			continue
+		}
+
+		allocated += numaState.AllocatedCPUSet.Size()
+		total += numaState.AllocatedCPUSet.Size() + numaState.GetAvailableCPUSet(reservedCPUs).Size()
+	}
+
+	if total == 0 {
+		return true, 0
+	}
+	// use permille to keep the ordering key integral
+	return true, allocated * 1000 / total
+}
+
+// availableCPUsHintPreferenceStrategy prefers hints with the minimal count of NUMA nodes needed,
+// and orders them by available cpus in their NUMA nodes: binpacking puts hints with less available
+// cpus first (best-fit), and spread puts hints with more available cpus first (worst-fit).
+type availableCPUsHintPreferenceStrategy struct {
+	binPacking bool
+}
+
+func (s availableCPUsHintPreferenceStrategy) Preferred(maskBits []int, minNUMAsCountNeeded int,
+	machineState state.NUMANodeMap, reservedCPUs machine.CPUSet) (bool, int) {
+	if len(maskBits) != minNUMAsCountNeeded {
+		return false, 0
+	}
+
+	available := 0
+	for _, numaID := range maskBits {
+		if numaState := machineState[numaID]; numaState != nil {
+			available += numaState.GetAvailableCPUSet(reservedCPUs).Size()
+		}
+	}
+
+	if s.binPacking {
+		return true, available
+	}
+	return true, -available
+}
+
+// applyHintPreferenceOrdering reorders each group of preferred hints with the same count of NUMA nodes
+// in the sorted hints by ordering keys of the strategy, and hints with the same key keep their order.
+func applyHintPreferenceOrdering(hints []*pluginapi.TopologyHint, strategy HintPreferenceStrategy,
+	minNUMAsCountNeeded int, machineState state.NUMANodeMap, reservedCPUs machine.CPUSet) {
+	getKey := func(hint *pluginapi.TopologyHint) int {
+		_, key := strategy.Preferred(util.HintToIntArray(hint), minNUMAsCountNeeded, machineState, reservedCPUs)
+		return key
+	}
+
+	// preferred hints of the same size are adjacent since hints are sorted by util.SortTopologyHints
+	for start := 0; start < len(hints) && hints[start].Preferred; {
+		end := start + 1
+		for end < len(hints) && hints[end].Preferred && len(hints[end].Nodes) == len(hints[start].Nodes) {
+			end++
+		}
+
+		group := hints[start:end]
+		sort.SliceStable(group, func(i, j int) bool {
+			return getKey(group[i]) < getKey(group[j])
+		})
+		start = end
+	}
+}
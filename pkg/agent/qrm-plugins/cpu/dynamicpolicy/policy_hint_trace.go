@@ -0,0 +1,143 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"encoding/json"
+	"sort"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	cpuconsts "github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// const variables for where traced hints come from
+const (
+	hintTraceSourceAllocated      = "allocated"
+	hintTraceSourceExtraStateFile = "extra_state_file"
+	hintTraceSourceCalculated     = "calculated"
+)
+
+// maxHintTraceEntries bounds the total count of rejected masks and hints recorded in a trace,
+// since the count of NUMA masks grows exponentially with the count of NUMA nodes.
+const maxHintTraceEntries = 64
+
+// hintTrace records how hints of a single request are generated for troubleshooting
+type hintTrace struct {
+	Source  string `json:"source"`
+	Request int    `json:"request"`
+	// AvailableCPUs is a map from NUMA id to the count of cpus available for the request
+	AvailableCPUs map[int]int      `json:"availableCPUs"`
+	Rejections    hintRejections   `json:"rejections,omitempty"`
+	Hints         []*hintTraceHint `json:"hints"`
+	// Best is NUMA nodes of the first preferred hint, which is the best one for the request
+	Best      string `json:"best,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+type hintTraceHint struct {
+	Nodes     string `json:"nodes"`
+	Preferred bool   `json:"preferred"`
+}
+
+// newHintTrace returns nil if the request doesn't opt into tracing, and all methods of hintTrace are no-op for nil
+func (p *DynamicPolicy) newHintTrace(req *pluginapi.ResourceRequest, reqInt int, machineState state.NUMANodeMap) *hintTrace {
+	if req.Annotations[cpuconsts.PodAnnotationHintTraceKey] != cpuconsts.PodAnnotationHintTraceEnable {
+		return nil
+	}
+
+	reservedCPUs := p.getHintReservedCPUs()
+	availableCPUs := make(map[int]int, len(machineState))
+	for numaID, numaState := range machineState {
+		if numaState == nil {
+			continue
+		}
+		availableCPUs[numaID] = numaState.GetAvailableCPUSet(reservedCPUs).Size()
+	}
+
+	return &hintTrace{
+		Request:       reqInt,
+		AvailableCPUs: availableCPUs,
+		Rejections:    make(hintRejections),
+	}
+}
+
+func (t *hintTrace) setSource(source string) {
+	if t == nil {
+		return
+	}
+	t.Source = source
+}
+
+// getRejections returns nil for nil hintTrace, so that rejections aren't collected if not traced
+func (t *hintTrace) getRejections() hintRejections {
+	if t == nil {
+		return nil
+	}
+	return t.Rejections
+}
+
+// report sets the trace with the given hints into annotations of hints response
+func (t *hintTrace) report(logger general.Logger, hints []*pluginapi.TopologyHint, resp *pluginapi.ResourceHintsResponse) {
+	if t == nil {
+		return
+	}
+
+	t.Hints = make([]*hintTraceHint, 0, len(hints))
+	for _, hint := range hints {
+		nodes := machine.NewCPUSet(util.HintToIntArray(hint)...).String()
+		if hint.Preferred && t.Best == "" {
+			t.Best = nodes
+		}
+
+		if len(t.Hints) < maxHintTraceEntries {
+			t.Hints = append(t.Hints, &hintTraceHint{Nodes: nodes, Preferred: hint.Preferred})
+		} else {
+			t.Truncated = true
+		}
+	}
+
+	// keep rejected masks with the smallest NUMA nodes (in cpuset format) to make the trace deterministic
+	if len(t.Hints)+len(t.Rejections) > maxHintTraceEntries {
+		masks := make([]string, 0, len(t.Rejections))
+		for mask := range t.Rejections {
+			masks = append(masks, mask)
+		}
+		sort.Strings(masks)
+
+		for _, mask := range masks[maxHintTraceEntries-len(t.Hints):] {
+			delete(t.Rejections, mask)
+		}
+		t.Truncated = true
+	}
+
+	traceBytes, err := json.Marshal(t)
+	if err != nil {
+		logger.Errorf("marshal hint trace failed with error: %v", err)
+		return
+	}
+
+	if resp.Annotations == nil {
+		resp.Annotations = make(map[string]string)
+	}
+	resp.Annotations[cpuconsts.ResourceHintsAnnotationHintTraceKey] = string(traceBytes)
+	logger.Infof("hint trace: %s", string(traceBytes))
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+	maputil "k8s.io/kubernetes/pkg/util/maps"
+
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// GetPodTopologyHints returns hints of all containers of a pod at once, keyed by container name.
+// for dedicated_cores with NUMA binding whose main container isn't allocated yet, hints are calculated
+// jointly by requests of the main container and sidecars with their own cpus, and all of them get
+// the same hints, so that the chosen NUMA nodes can hold the whole pod; otherwise (or if the joint
+// calculation fails) each container gets the same hints as GetTopologyHints, which is still
+// the path used by topology manager.
+func (p *DynamicPolicy) GetPodTopologyHints(ctx context.Context,
+	reqs []*pluginapi.ResourceRequest) (map[string]*pluginapi.ResourceHintsResponse, error) {
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("GetPodTopologyHints got empty reqs")
+	}
+
+	var mainReq *pluginapi.ResourceRequest
+	for _, req := range reqs {
+		if req == nil {
+			return nil, fmt.Errorf("GetPodTopologyHints got nil req")
+		} else if req.PodUid != reqs[0].PodUid {
+			return nil, fmt.Errorf("GetPodTopologyHints got reqs of different pods: %s and %s", reqs[0].PodUid, req.PodUid)
+		}
+
+		if req.ContainerType == pluginapi.ContainerType_MAIN {
+			mainReq = req
+		}
+	}
+
+	resps := make(map[string]*pluginapi.ResourceHintsResponse, len(reqs))
+	if mainReq != nil {
+		jointResps, err := p.getJointTopologyHints(ctx, mainReq, reqs)
+		if err != nil {
+			general.Errorf("get joint topology hints for pod: %s/%s failed with error: %v, fall back to hints per container",
+				mainReq.PodNamespace, mainReq.PodName, err)
+		}
+
+		for containerName, resp := range jointResps {
+			resps[containerName] = resp
+		}
+	}
+
+	for _, req := range reqs {
+		if resps[req.ContainerName] != nil {
+			continue
+		}
+
+		resp, err := p.GetTopologyHints(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("GetTopologyHints for pod: %s/%s, container: %s failed with error: %v",
+				req.PodNamespace, req.PodName, req.ContainerName, err)
+		}
+		resps[req.ContainerName] = resp
+	}
+	return resps, nil
+}
+
+// getJointTopologyHints returns hints of the main container and sidecars with their own cpus calculated
+// by their total request, and it returns nil if hints don't need to be calculated jointly, e.g. sidecars
+// can't get cpus of their own for numa_exclusive main container.
+func (p *DynamicPolicy) getJointTopologyHints(ctx context.Context, mainReq *pluginapi.ResourceRequest,
+	reqs []*pluginapi.ResourceRequest) (map[string]*pluginapi.ResourceHintsResponse, error) {
+	// GetKatalystQoSLevelFromResourceReq overwrites annotations and labels, so work on a copy
+	jointReq := *mainReq
+	jointReq.Annotations = maputil.CopySS(mainReq.Annotations)
+	jointReq.Labels = maputil.CopySS(mainReq.Labels)
+	jointReq.ResourceRequests = make(map[string]float64, len(mainReq.ResourceRequests))
+	for resourceName, quantity := range mainReq.ResourceRequests {
+		jointReq.ResourceRequests[resourceName] = quantity
+	}
+
+	qosLevel, err := util.GetKatalystQoSLevelFromResourceReq(p.qosConfig, &jointReq)
+	if err != nil {
+		return nil, fmt.Errorf("GetKatalystQoSLevelFromResourceReq failed with error: %v", err)
+	} else if qosLevel != consts.PodAnnotationQoSLevelDedicatedCores ||
+		!qosutil.AnnotationsIndicateNUMABinding(jointReq.Annotations) || !checkSidecarOwnCPUs(mainReq.Annotations) {
+		return nil, nil
+	} else if qosutil.AnnotationsIndicateNUMAExclusive(jointReq.Annotations) {
+		// sidecars can't get cpus of their own, so they shouldn't be counted into the joint request,
+		// and the error is returned by hints of each container instead.
+		return nil, nil
+	}
+
+	p.RLock()
+	mainAllocated := p.state.GetAllocationInfo(mainReq.PodUid, mainReq.ContainerName) != nil
+	p.RUnlock()
+	if mainAllocated {
+		return nil, nil
+	}
+
+	sidecarReqs := make([]*pluginapi.ResourceRequest, 0, len(reqs))
+	for _, req := range reqs {
+		// only sidecars allocated with cpus of their own by dedicatedCoresWithNUMABindingAllocationSidecarHandler
+		// take up extra cpus in NUMA nodes of the main container, others share cpus of the main container
+		if req.ContainerType != pluginapi.ContainerType_SIDECAR || !checkSidecarOwnCPUs(req.Annotations) {
+			continue
+		}
+
+		reqInt, err := util.GetQuantityFromResourceReq(req)
+		if err != nil {
+			return nil, fmt.Errorf("GetQuantityFromResourceReq for sidecar: %s failed with error: %v", req.ContainerName, err)
+		}
+
+		jointReq.ResourceRequests[string(v1.ResourceCPU)] += float64(reqInt)
+		sidecarReqs = append(sidecarReqs, req)
+	}
+
+	if len(sidecarReqs) == 0 {
+		return nil, nil
+	}
+
+	// annotations of jointReq have been filtered, so use the original ones of the main container
+	jointReq.Annotations = maputil.CopySS(mainReq.Annotations)
+	jointResp, err := p.GetTopologyHints(ctx, &jointReq)
+	if err != nil {
+		return nil, fmt.Errorf("GetTopologyHints for joint request: %v failed with error: %v",
+			jointReq.ResourceRequests[string(v1.ResourceCPU)], err)
+	}
+
+	resps := map[string]*pluginapi.ResourceHintsResponse{
+		mainReq.ContainerName: jointResp,
+	}
+	for _, req := range sidecarReqs {
+		resp, err := util.PackResourceHintsResponse(req, string(v1.ResourceCPU), jointResp.ResourceHints)
+		if err != nil {
+			return nil, fmt.Errorf("PackResourceHintsResponse for sidecar: %s failed with error: %v", req.ContainerName, err)
+		}
+		resps[req.ContainerName] = resp
+	}
+	return resps, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicpolicy
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+	maputil "k8s.io/kubernetes/pkg/util/maps"
+
+	"github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/util"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
+)
+
+// HintsSimulation is the result of SimulateHints
+type HintsSimulation struct {
+	// Hints are the candidate hints for the request, nil means there is no NUMA preference
+	Hints []*pluginapi.TopologyHint `json:"hints"`
+	// Reason explains why there is no candidate hint, and it's empty if Hints isn't empty
+	Reason string `json:"reason,omitempty"`
+}
+
+// SimulateHints previews cpu hints for the given request against a snapshot of current state,
+// it never mutates state, so it's safe to be called concurrently with real allocations.
+// hints are generated in the same way as GetTopologyHints, including extra state-file and
+// adjustments by memory, NUMA hint of scheduler and coordinated NUMA.
+// only dedicated_cores with NUMA binding get NUMA preference, just like GetTopologyHints.
+func (p *DynamicPolicy) SimulateHints(req *pluginapi.ResourceRequest) (*HintsSimulation, error) {
+	if req == nil {
+		return nil, fmt.Errorf("SimulateHints got nil req")
+	}
+
+	// GetKatalystQoSLevelFromResourceReq overwrites annotations and labels, so work on a copy
+	simReq := *req
+	simReq.Annotations = maputil.CopySS(req.Annotations)
+	simReq.Labels = maputil.CopySS(req.Labels)
+
+	// cpu plugin specific annotations will also be filtered, so keep them for hints calculation
+	pluginAnnotations := getPluginAnnotations(req.Annotations)
+	qosLevel, err := util.GetKatalystQoSLevelFromResourceReq(p.qosConfig, &simReq)
+	if err != nil {
+		return nil, fmt.Errorf("GetKatalystQoSLevelFromResourceReq failed with error: %v", err)
+	}
+
+	for key, val := range pluginAnnotations {
+		simReq.Annotations[key] = val
+	}
+
+	reqInt, err := util.GetQuantityFromResourceReq(&simReq)
+	if err != nil {
+		return nil, fmt.Errorf("getReqQuantityFromResourceReq failed with error: %v", err)
+	}
+
+	if qosLevel != consts.PodAnnotationQoSLevelDedicatedCores ||
+		!qosutil.AnnotationsIndicateNUMABinding(simReq.Annotations) ||
+		simReq.ContainerType != pluginapi.ContainerType_MAIN {
+		return &HintsSimulation{}, nil
+	}
+
+	if p.numaBindingUnsupportedErr != nil {
+		return &HintsSimulation{
+			Hints:  []*pluginapi.TopologyHint{},
+			Reason: fmt.Sprintf("NUMA binding isn't supported on this machine: %v", p.numaBindingUnsupportedErr),
+		}, nil
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	// both of pod entries and machine state are cloned by state getters
+	podEntries := p.state.GetPodEntries()
+	machineState := p.state.GetMachineState()
+
+	if allocationInfo := podEntries[simReq.PodUid][simReq.ContainerName]; allocationInfo != nil {
+		if hints := p.regenerateHints(allocationInfo, reqInt); hints != nil {
+			return &HintsSimulation{Hints: hints[string(v1.ResourceCPU)].Hints}, nil
+		}
+
+		// simulate the discarding of the existing allocation on the cloned pod entries
+		delete(podEntries[simReq.PodUid], simReq.ContainerName)
+		if len(podEntries[simReq.PodUid]) == 0 {
+			delete(podEntries, simReq.PodUid)
+		}
+
+		machineState, err = generateMachineStateFromPodEntries(p.machineInfo.CPUTopology, podEntries)
+		if err != nil {
+			return nil, fmt.Errorf("GenerateMachineStateFromPodEntries failed with error: %v", err)
+		}
+	}
+
+	hints, err := p.calculateNUMABindingHints(getHintLogger(&simReq, reqInt), &simReq, reqInt, machineState, nil, nil)
+	if err != nil {
+		return &HintsSimulation{
+			Hints:  []*pluginapi.TopologyHint{},
+			Reason: err.Error(),
+		}, nil
+	} else if len(hints[string(v1.ResourceCPU)].Hints) == 0 {
+		return &HintsSimulation{
+			Hints:  []*pluginapi.TopologyHint{},
+			Reason: fmt.Sprintf("no NUMA nodes can satisfy request: %d", reqInt),
+		}, nil
+	}
+
+	return &HintsSimulation{Hints: hints[string(v1.ResourceCPU)].Hints}, nil
+}
@@ -21,18 +21,23 @@ package dynamicpolicy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/events"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+	"k8s.io/kubernetes/pkg/kubelet/cm/topologymanager/bitmask"
 	utilfs "k8s.io/kubernetes/pkg/util/filesystem"
 
 	"github.com/stretchr/testify/require"
@@ -53,6 +58,7 @@ import (
 	"github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
 	cgroupcm "github.com/kubewharf/katalyst-core/pkg/util/cgroup/common"
 	cgroupcmutils "github.com/kubewharf/katalyst-core/pkg/util/cgroup/manager"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
 
@@ -692,6 +698,45 @@ func TestAllocate(t *testing.T) {
 	}
 }
 
+func TestCalculateReclaimedHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateReclaimedHints")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// NUMA 1 has the most available cpus after taking some of others
+	machineState := dynamicPolicy.state.GetMachineState()
+	machineState[0].DefaultCPUSet = machine.NewCPUSet(1)
+	machineState[2].DefaultCPUSet = machine.NewCPUSet()
+	machineState[3].DefaultCPUSet = machine.NewCPUSet(7)
+
+	hints := dynamicPolicy.calculateReclaimedHints(machineState)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: false},
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{2}, Preferred: false},
+		{Nodes: []uint64{3}, Preferred: false},
+	}, hints[string(v1.ResourceCPU)].Hints)
+
+	// no NUMA node has available cpus, so there is no NUMA preference instead of no preferred hint
+	for _, numaState := range machineState {
+		numaState.DefaultCPUSet = machine.NewCPUSet()
+	}
+
+	hints = dynamicPolicy.calculateReclaimedHints(machineState)
+	as.Contains(hints, string(v1.ResourceCPU))
+	as.Nil(hints[string(v1.ResourceCPU)])
+}
+
 func TestGetTopologyHints(t *testing.T) {
 	t.Parallel()
 
@@ -772,7 +817,26 @@ func TestGetTopologyHints(t *testing.T) {
 				ContainerIndex: 0,
 				ResourceName:   string(v1.ResourceCPU),
 				ResourceHints: map[string]*pluginapi.ListOfTopologyHints{
-					string(v1.ResourceCPU): nil,
+					string(v1.ResourceCPU): {
+						Hints: []*pluginapi.TopologyHint{
+							{
+								Nodes:     []uint64{0},
+								Preferred: false,
+							},
+							{
+								Nodes:     []uint64{1},
+								Preferred: false,
+							},
+							{
+								Nodes:     []uint64{2},
+								Preferred: true,
+							},
+							{
+								Nodes:     []uint64{3},
+								Preferred: true,
+							},
+						},
+					},
 				},
 				Labels: map[string]string{
 					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelReclaimedCores,
@@ -1467,6 +1531,55 @@ func TestGetResourcesAllocation(t *testing.T) {
 	})
 }
 
+func TestAllocateWithFractionalRequest(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestAllocateWithFractionalRequest")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	req := &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{0},
+			Preferred: true,
+		},
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 1.5,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "false"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	// cpus are allocated by the rounded request
+	allocationInfo := dynamicPolicy.state.GetAllocationInfo(req.PodUid, req.ContainerName)
+	as.NotNil(allocationInfo)
+	as.Equal(2, allocationInfo.AllocationResult.Size())
+	as.Equal(2, allocationInfo.RequestQuantity)
+}
+
 func TestAllocateByQoSAwareServerListAndWatchResp(t *testing.T) {
 	t.Parallel()
 
@@ -3099,6 +3212,79 @@ func TestRemoveContainer(t *testing.T) {
 	as.Nil(allocationInfo)
 }
 
+func TestSharedCoresHintsWithSharedPoolNUMAPreference(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestSharedCoresHintsWithSharedPoolNUMAPreference")
+	as.Nil(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	newReq := func(preference bool) *pluginapi.ResourceRequest {
+		req := &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelSharedCores,
+			},
+		}
+		if preference {
+			req.Annotations[cpuconsts.PodAnnotationSharedPoolNUMAPreferenceKey] = cpuconsts.PodAnnotationSharedPoolNUMAPreferenceEnable
+		}
+		return req
+	}
+
+	// no numa preference if the shared pool doesn't exist yet
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq(true))
+	as.Nil(err)
+	as.Nil(resp.ResourceHints[string(v1.ResourceCPU)])
+
+	dynamicPolicy.state.SetAllocationInfo(state.PoolNameShare, advisorapi.FakedContainerName, &state.AllocationInfo{
+		PodUid:                   state.PoolNameShare,
+		OwnerPoolName:            state.PoolNameShare,
+		AllocationResult:         machine.MustParse("4-6,12"),
+		OriginalAllocationResult: machine.MustParse("4-6,12"),
+		TopologyAwareAssignments: map[int]machine.CPUSet{
+			2: machine.NewCPUSet(4, 5, 12),
+			3: machine.NewCPUSet(6),
+		},
+		OriginalTopologyAwareAssignments: map[int]machine.CPUSet{
+			2: machine.NewCPUSet(4, 5, 12),
+			3: machine.NewCPUSet(6),
+		},
+	})
+
+	// no numa preference without the annotation
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(false))
+	as.Nil(err)
+	as.Nil(resp.ResourceHints[string(v1.ResourceCPU)])
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(true))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: false},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+}
+
 func TestShoudSharedCoresRampUp(t *testing.T) {
 	t.Parallel()
 
@@ -3212,3 +3398,2745 @@ func TestShoudSharedCoresRampUp(t *testing.T) {
 	as.Equal(false, allocationInfo.RampUp)
 	as.Equal(allocationInfo.OwnerPoolName, state.PoolNameShare)
 }
+
+type recordedMetricsEmitter struct {
+	metrics.DummyMetrics
+
+	sync.Mutex
+	values map[string]int64
+	counts map[string]int
+}
+
+func (r *recordedMetricsEmitter) StoreInt64(key string, val int64, _ metrics.MetricTypeName, tags ...metrics.MetricTag) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.values == nil {
+		r.values = make(map[string]int64)
+		r.counts = make(map[string]int)
+	}
+
+	tagStrs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tagStrs = append(tagStrs, fmt.Sprintf("%s=%s", tag.Key, tag.Val))
+	}
+	sort.Strings(tagStrs)
+	metricKey := fmt.Sprintf("%s{%s}", key, strings.Join(tagStrs, ","))
+	r.values[metricKey] = val
+	r.counts[metricKey]++
+	return nil
+}
+
+func TestEmitNUMAMetrics(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestEmitNUMAMetrics")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	recorder := &recordedMetricsEmitter{}
+	dynamicPolicy.emitter = recorder
+
+	req := &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{2},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	dynamicPolicy.emitNUMAMetrics()
+
+	numa2Tags := "{numaID=2,socketID=1}"
+	as.Equal(int64(0), recorder.values[util.MetricNameNUMAAvailableCPUs+numa2Tags])
+	as.Equal(int64(4), recorder.values[util.MetricNameNUMAAllocatedCPUs+numa2Tags])
+	as.Equal(int64(1), recorder.values[util.MetricNameNUMAPodCount+numa2Tags])
+	as.Equal(int64(1), recorder.values[util.MetricNameNUMAExclusive+numa2Tags])
+
+	numa3Tags := "{numaID=3,socketID=1}"
+	as.Equal(int64(0), recorder.values[util.MetricNameNUMAAllocatedCPUs+numa3Tags])
+	as.Equal(int64(0), recorder.values[util.MetricNameNUMAExclusive+numa3Tags])
+	as.Equal(int64(4), recorder.values[util.MetricNameNUMAAvailableCPUs+numa3Tags])
+	as.Equal(int64(0), recorder.values[util.MetricNameNUMAPodCount+numa3Tags])
+}
+
+func TestCalculateHintsDeterministicOrder(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsDeterministicOrder")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	calculateStableHints := func() []*pluginapi.TopologyHint {
+		var expectedHints []*pluginapi.TopologyHint
+		for i := 0; i < 10; i++ {
+			hints, err := dynamicPolicy.calculateHints(2, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+			as.Nil(err)
+
+			cpuHints := hints[string(v1.ResourceCPU)].Hints
+			for j := 1; j < len(cpuHints); j++ {
+				// preferred hints must never follow non-preferred ones, and fewer NUMAs come first within each of them
+				as.False(!cpuHints[j-1].Preferred && cpuHints[j].Preferred)
+				if cpuHints[j-1].Preferred == cpuHints[j].Preferred {
+					as.LessOrEqual(len(cpuHints[j-1].Nodes), len(cpuHints[j].Nodes))
+				}
+			}
+
+			if expectedHints == nil {
+				expectedHints = cpuHints
+				continue
+			}
+			as.Equal(expectedHints, cpuHints)
+		}
+		return expectedHints
+	}
+
+	// preferred hints of the same size are ordered by the configured strategy,
+	// and the default one orders them by ascending NUMA ids
+	expectedHints := calculateStableHints()
+	as.Equal([]uint64{0}, expectedHints[0].Nodes)
+	as.True(expectedHints[0].Preferred)
+
+	// spread puts NUMA 2 and 3 without reserved cpus first
+	dynamicPolicy.hintPreferenceStrategy, err = GetHintPreferenceStrategy(cpuconsts.CPUNUMAAllocationStrategySpread)
+	as.Nil(err)
+	expectedHints = calculateStableHints()
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{1}, Preferred: true},
+	}, expectedHints[:4])
+}
+
+func TestDefragmentationPlan(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestDefragmentationPlan")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	allocate := func(podName string, reqInt float64, numaID uint64, numaExclusive bool) {
+		memoryEnhancement := `{"numa_binding": "true"}`
+		if numaExclusive {
+			memoryEnhancement = `{"numa_binding": "true", "numa_exclusive": "true"}`
+		}
+
+		_, err := dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         podName + "-uid",
+			PodNamespace:   "test",
+			PodName:        podName,
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Hint: &pluginapi.TopologyHint{
+				Nodes:     []uint64{numaID},
+				Preferred: true,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: memoryEnhancement,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+		as.Nil(err)
+	}
+
+	as.Empty(dynamicPolicy.DefragmentationPlan())
+
+	allocate("pod-d", 1, 0, false)
+	allocate("pod-c", 2, 1, true)
+	allocate("pod-a", 2, 2, false)
+	allocate("pod-b", 1, 3, false)
+
+	// NUMA 1 is pinned by numa_exclusive pod, and NUMA 2 with the least available cpus receives the others
+	as.Equal([]NUMAMove{
+		{
+			PodRef:        PodRef{PodUID: "pod-d-uid", PodNamespace: "test", PodName: "pod-d"},
+			ContainerName: "test",
+			FromNUMA:      0,
+			ToNUMA:        2,
+		},
+		{
+			PodRef:        PodRef{PodUID: "pod-b-uid", PodNamespace: "test", PodName: "pod-b"},
+			ContainerName: "test",
+			FromNUMA:      3,
+			ToNUMA:        2,
+		},
+	}, dynamicPolicy.DefragmentationPlan())
+}
+
+func TestEvictionPriority(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestEvictionPriority")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	allocate := func(name string, cpus float64, numaID uint64, exclusive bool) string {
+		memoryEnhancement := `{"numa_binding": "true"}`
+		if exclusive {
+			memoryEnhancement = `{"numa_binding": "true", "numa_exclusive": "true"}`
+		}
+
+		req := &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        name,
+			ContainerName:  name,
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): cpus,
+			},
+			Hint: &pluginapi.TopologyHint{
+				Nodes:     []uint64{numaID},
+				Preferred: true,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: memoryEnhancement,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+		_, err := dynamicPolicy.Allocate(context.Background(), req)
+		as.Nil(err)
+		return req.PodUid
+	}
+
+	// pod-a owns NUMA 2 exclusively, pod-b and pod-c share NUMA 3
+	podA := allocate("pod-a", 2, 2, true)
+	podB := allocate("pod-b", 1, 3, false)
+	podC := allocate("pod-c", 2, 3, false)
+
+	refs := dynamicPolicy.EvictionPriority([]string{podB, podC, podA, "unknown-pod"})
+	as.Equal([]PodRef{
+		{PodUID: podA, PodNamespace: "test", PodName: "pod-a"},
+		{PodUID: podC, PodNamespace: "test", PodName: "pod-c"},
+		{PodUID: podB, PodNamespace: "test", PodName: "pod-b"},
+	}, refs)
+
+	as.Empty(dynamicPolicy.EvictionPriority(nil))
+}
+
+func TestCheckStateInvariants(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCheckStateInvariants")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	recorder := &recordedMetricsEmitter{}
+	dynamicPolicy.emitter = recorder
+	dynamicPolicy.enableStateInvariantRebuild = true
+
+	req := &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{2},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	as.Empty(dynamicPolicy.getStateInvariantViolations(dynamicPolicy.state.GetPodEntries(),
+		dynamicPolicy.state.GetMachineState()))
+
+	// corrupt machine state with cpus from reserved and other NUMA nodes
+	expectedMachineState := dynamicPolicy.state.GetMachineState()
+	corruptedMachineState := dynamicPolicy.state.GetMachineState()
+	corruptedMachineState[2].AllocatedCPUSet = corruptedMachineState[2].AllocatedCPUSet.Union(machine.NewCPUSet(0))
+	dynamicPolicy.state.SetMachineState(corruptedMachineState)
+
+	// allocated beyond NUMA, overlapped with reserved and mismatched with pod entries
+	as.Len(dynamicPolicy.getStateInvariantViolations(dynamicPolicy.state.GetPodEntries(),
+		dynamicPolicy.state.GetMachineState()), 3)
+
+	dynamicPolicy.checkStateInvariants()
+	as.Equal(int64(3), recorder.values[util.MetricNameStateInvariantViolated+"{}"])
+	as.Equal(expectedMachineState, dynamicPolicy.state.GetMachineState())
+}
+
+func TestCalculateHintsWithoutPreferred(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	for _, promotion := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithoutPreferred")
+		as.Nil(err)
+
+		dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+		as.Nil(err)
+		dynamicPolicy.enableNonPreferredHintPromotion = promotion
+
+		// occupy NUMA 2 and 3 exclusively, so that a whole-NUMA request
+		// only fits into NUMA 0 and 1 together, since each of them has a reserved cpu
+		for _, numaID := range []uint64{2, 3} {
+			_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+				PodUid:         string(uuid.NewUUID()),
+				PodNamespace:   "test",
+				PodName:        "test",
+				ContainerName:  "test",
+				ContainerType:  pluginapi.ContainerType_MAIN,
+				ContainerIndex: 0,
+				ResourceName:   string(v1.ResourceCPU),
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2,
+				},
+				Hint: &pluginapi.TopologyHint{
+					Nodes:     []uint64{numaID},
+					Preferred: true,
+				},
+				Annotations: map[string]string{
+					consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+					consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+				},
+				Labels: map[string]string{
+					consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+				},
+			})
+			as.Nil(err)
+		}
+
+		hints, err := dynamicPolicy.calculateHints(4, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+		as.Nil(err)
+		as.Equal([]*pluginapi.TopologyHint{
+			{
+				Nodes:     []uint64{0, 1},
+				Preferred: promotion,
+			},
+		}, hints[string(v1.ResourceCPU)].Hints)
+
+		_ = os.RemoveAll(tmpDir)
+	}
+}
+
+func TestMinNUMAsForRequest(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestMinNUMAsForRequest")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	count, err := dynamicPolicy.MinNUMAsForRequest(8)
+	as.Nil(err)
+	as.Equal(2, count)
+
+	// reserved cpus 0 and 2 occupy NUMA 0 and 1, but NUMA 2 and 3 are still whole
+	count, err = dynamicPolicy.MinNUMAsForRequestWithReserved(8)
+	as.Nil(err)
+	as.Equal(2, count)
+
+	count, err = dynamicPolicy.MinNUMAsForRequest(12)
+	as.Nil(err)
+	as.Equal(3, count)
+
+	count, err = dynamicPolicy.MinNUMAsForRequestWithReserved(12)
+	as.Nil(err)
+	as.Equal(4, count)
+
+	_, err = dynamicPolicy.MinNUMAsForRequestWithReserved(16)
+	as.NotNil(err)
+}
+
+func TestGetNUMAAvailability(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestGetNUMAAvailability")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// reserved cpus 0 and 2 are excluded
+	as.Equal(map[int]int{0: 3, 1: 3, 2: 4, 3: 4}, dynamicPolicy.GetNUMAAvailability())
+
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{2},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	// the availability should match what hints are calculated with
+	availability := dynamicPolicy.GetNUMAAvailability()
+	as.Equal(map[int]int{0: 3, 1: 3, 2: 2, 3: 4}, availability)
+
+	hints, err := dynamicPolicy.calculateHints(3, "test", dynamicPolicy.state.GetMachineState(), map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	})
+	as.Nil(err)
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		as.GreaterOrEqual(availability[int(hint.Nodes[0])], 3)
+	}
+}
+
+func TestCalculateHintsWithNamespaceNUMABudget(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithNamespaceNUMABudget")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.namespaceNUMABudget = map[string]int{"test": 1}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{2},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	as.Equal(map[string]machine.CPUSet{"test": machine.NewCPUSet(2)}, dynamicPolicy.GetNamespaceNUMAUsage())
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	// namespace test has used up its budget with NUMA 2
+	_, err = dynamicPolicy.calculateHints(2, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+	as.NotNil(err)
+
+	// namespaces without budget are unlimited
+	hints, err := dynamicPolicy.calculateHints(2, "other", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+	as.Nil(err)
+	as.NotEmpty(hints[string(v1.ResourceCPU)].Hints)
+}
+
+func TestCalculateHintsWithSocketSelectionStrategy(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+
+	testCases := []struct {
+		strategy      string
+		expectedHints []*pluginapi.TopologyHint
+	}{
+		{
+			strategy: cpuconsts.CPUSocketSelectionStrategyBalance,
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: false},
+			},
+		},
+		{
+			strategy: cpuconsts.CPUSocketSelectionStrategyPack,
+			expectedHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{3}, Preferred: true},
+				{Nodes: []uint64{0}, Preferred: false},
+				{Nodes: []uint64{1}, Preferred: false},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithSocketSelectionStrategy")
+		as.Nil(err)
+
+		dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+		as.Nil(err)
+		dynamicPolicy.socketSelectionStrategy = tc.strategy
+
+		// occupy NUMA 2 in socket 1 exclusively
+		_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Hint: &pluginapi.TopologyHint{
+				Nodes:     []uint64{2},
+				Preferred: true,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+		as.Nil(err)
+
+		hints, err := dynamicPolicy.calculateHints(2, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+		as.Nil(err)
+		as.Equalf(tc.expectedHints, hints[string(v1.ResourceCPU)].Hints, "failed in strategy: %s", tc.strategy)
+
+		_ = os.RemoveAll(tmpDir)
+	}
+}
+
+func TestPreviewConfig(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestPreviewConfig")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	podUIDs := make([]string, 0, 2)
+	for _, numaID := range []uint64{2, 3} {
+		req := &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        fmt.Sprintf("test-%d", numaID),
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Hint: &pluginapi.TopologyHint{
+				Nodes:     []uint64{numaID},
+				Preferred: true,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+		_, err = dynamicPolicy.Allocate(context.Background(), req)
+		as.Nil(err)
+		podUIDs = append(podUIDs, req.PodUid)
+	}
+	sort.Strings(podUIDs)
+
+	report := dynamicPolicy.PreviewConfig(NUMAAllocationConfig{NamespaceNUMABudget: map[string]int{"test": 1}})
+	as.Len(report.Violations, 2)
+	for i, violation := range report.Violations {
+		as.Equal(podUIDs[i], violation.PodUID)
+		as.Equal("test", violation.PodNamespace)
+	}
+
+	report = dynamicPolicy.PreviewConfig(NUMAAllocationConfig{NamespaceNUMABudget: map[string]int{"test": 2, "other": 0}})
+	as.Empty(report.Violations)
+
+	// preview never applies the config
+	as.Nil(dynamicPolicy.namespaceNUMABudget)
+}
+
+func TestDedicatedCoresWithoutNUMABinding(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestDedicatedCoresWithoutNUMABinding")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	newReq := func() *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         "dedicated-pod",
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 6,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	// no numa preference before allocation
+	hintsResp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Nil(hintsResp.ResourceHints[string(v1.ResourceCPU)])
+
+	_, err = dynamicPolicy.Allocate(context.Background(), newReq())
+	as.Nil(err)
+
+	allocationInfo := dynamicPolicy.state.GetAllocationInfo("dedicated-pod", "test")
+	as.NotNil(allocationInfo)
+	as.Equal(state.PoolNameDedicated, allocationInfo.OwnerPoolName)
+	as.Equal(6, allocationInfo.AllocationResult.Size())
+	as.True(allocationInfo.AllocationResult.Intersection(dynamicPolicy.reservedCPUs).IsEmpty())
+
+	// isolated cpus are excluded from all pools
+	for poolName, containerEntries := range dynamicPolicy.state.GetPodEntries() {
+		if !containerEntries.IsPoolEntry() {
+			continue
+		}
+		as.Truef(containerEntries.GetPoolEntry().AllocationResult.Intersection(allocationInfo.AllocationResult).IsEmpty(),
+			"pool: %s overlaps with isolated cpus", poolName)
+	}
+
+	// hints are regenerated from the existing allocation
+	hintsResp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{
+			Nodes:     machine.MaskToUInt64Array(mustMaskFromAssignments(t, allocationInfo.TopologyAwareAssignments)),
+			Preferred: true,
+		},
+	}, hintsResp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// allocating again is idempotent
+	resp, err := dynamicPolicy.Allocate(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal(allocationInfo.AllocationResult.String(),
+		resp.AllocationResult.ResourceAllocation[string(v1.ResourceCPU)].AllocationResult)
+
+	// isolated cpus are allocated in machine state, so they aren't available for NUMA binding containers
+	isolatedCPUs := allocationInfo.AllocationResult.Clone()
+	as.True(dynamicPolicy.state.GetMachineState().GetDefaultCPUSet().Intersection(isolatedCPUs).IsEmpty())
+
+	bindingReq := &pluginapi.ResourceRequest{
+		PodUid:         "binding-pod",
+		PodNamespace:   "test",
+		PodName:        "binding",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 1,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "false"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	}
+	for numaID := range allocationInfo.TopologyAwareAssignments {
+		if allocationInfo.TopologyAwareAssignments[numaID].Size() > 0 {
+			bindingReq.Hint = &pluginapi.TopologyHint{Nodes: []uint64{uint64(numaID)}, Preferred: true}
+			break
+		}
+	}
+
+	_, err = dynamicPolicy.Allocate(context.Background(), bindingReq)
+	as.Nil(err)
+
+	bindingAllocationInfo := dynamicPolicy.state.GetAllocationInfo("binding-pod", "test")
+	as.NotNil(bindingAllocationInfo)
+	as.True(bindingAllocationInfo.AllocationResult.Intersection(isolatedCPUs).IsEmpty())
+
+	allocationInfo = dynamicPolicy.state.GetAllocationInfo("dedicated-pod", "test")
+	as.NotNil(allocationInfo)
+	as.True(bindingAllocationInfo.AllocationResult.Intersection(allocationInfo.AllocationResult).IsEmpty())
+}
+
+func mustMaskFromAssignments(t *testing.T, assignments map[int]machine.CPUSet) bitmask.BitMask {
+	numaNodes := make([]int, 0, len(assignments))
+	for numaNode, cset := range assignments {
+		if cset.Size() > 0 {
+			numaNodes = append(numaNodes, numaNode)
+		}
+	}
+
+	mask, err := bitmask.NewBitMask(numaNodes...)
+	require.NoError(t, err)
+	return mask
+}
+
+func TestReclaimedCoresHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestReclaimedCoresHints")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{2},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "reclaimed",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelReclaimedCores,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelReclaimedCores,
+		},
+	})
+	as.Nil(err)
+
+	// NUMA 0 and 1 hold reserved cpus and NUMA 2 is taken by the NUMA-binding pod,
+	// so only NUMA 3 has the largest room
+	preferred := make([][]uint64, 0)
+	nodes := make([][]uint64, 0)
+	for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+		nodes = append(nodes, hint.Nodes)
+		if hint.Preferred {
+			preferred = append(preferred, hint.Nodes)
+		}
+	}
+	as.Equal([][]uint64{{0}, {1}, {2}, {3}}, nodes)
+	as.Equal([][]uint64{{3}}, preferred)
+}
+
+func TestRecordAllocationDiscarded(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestRecordAllocationDiscarded")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	emitter := &recordedMetricsEmitter{}
+	dynamicPolicy.emitter = emitter
+	recorder := events.NewFakeRecorder(1)
+	dynamicPolicy.recorder = recorder
+
+	newReq := func(reqInt float64) *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         "pod-uid",
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	req := newReq(2)
+	req.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	// the whole NUMA 2 is allocated, so a larger request can't regenerate hints from it
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(5))
+	as.Nil(err)
+
+	as.Equal(int64(1), emitter.values[fmt.Sprintf("%s{containerName=test,podName=test,podNamespace=test}",
+		util.MetricNameAllocationDiscarded)])
+
+	select {
+	case event := <-recorder.Events:
+		as.Contains(event, cpuconsts.EventReasonAllocationDiscarded)
+		as.Contains(event, "4-5,12-13")
+	default:
+		as.Fail("expected an event for the discarded allocation")
+	}
+}
+
+func TestRegenerateHintsWithReservedCPUsChanged(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestRegenerateHintsWithReservedCPUsChanged")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	emitter := &recordedMetricsEmitter{}
+	dynamicPolicy.emitter = emitter
+
+	newReq := func() *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         "pod-uid",
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	req := newReq()
+	req.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2}, Preferred: true}}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+	as.Zero(emitter.values[fmt.Sprintf("%s{containerName=test,podName=test,podNamespace=test}",
+		util.MetricNameAllocationDiscarded)])
+
+	// reserved cpus grow into the allocation, so it's discarded and hints are re-calculated
+	dynamicPolicy.reservedCPUs = dynamicPolicy.reservedCPUs.Union(machine.NewCPUSet(4))
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal(int64(1), emitter.values[fmt.Sprintf("%s{containerName=test,podName=test,podNamespace=test}",
+		util.MetricNameAllocationDiscarded)])
+}
+
+func TestCheckAllocationNUMAChanged(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCheckAllocationNUMAChanged")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	emitter := &recordedMetricsEmitter{}
+	dynamicPolicy.emitter = emitter
+
+	newReq := func(reqInt float64, hintNodes []uint64) *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         "pod-uid",
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Hint: &pluginapi.TopologyHint{
+				Nodes:     hintNodes,
+				Preferred: true,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	metricKey := fmt.Sprintf("%s{containerName=test,podName=test,podNamespace=test}", util.MetricNameAllocationNUMAChanged)
+
+	_, err = dynamicPolicy.Allocate(context.Background(), newReq(2, []uint64{2}))
+	as.Nil(err)
+	as.NotContains(emitter.values, metricKey)
+
+	// the resized container can't fit into NUMA 2 any more, and it's re-allocated to NUMA 2 and 3
+	_, err = dynamicPolicy.Allocate(context.Background(), newReq(5, []uint64{2, 3}))
+	as.Nil(err)
+	as.Equal(int64(1), emitter.values[metricKey])
+}
+
+func TestCalculateHintsWithStrictNUMAExclusive(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithStrictNUMAExclusive")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	hintNodes := func() [][]uint64 {
+		hints, err := dynamicPolicy.calculateHints(6, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+		as.Nil(err)
+
+		res := make([][]uint64, 0)
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			res = append(res, hint.Nodes)
+		}
+		return res
+	}
+
+	as.Equal([][]uint64{{0, 1}, {2, 3}, {0, 1, 2}, {0, 1, 3}, {0, 2, 3}, {1, 2, 3}, {0, 1, 2, 3}}, hintNodes())
+
+	// masks with more NUMA nodes than needed leave some of them partially used
+	dynamicPolicy.enableStrictNUMAExclusiveHints = true
+	as.Equal([][]uint64{{0, 1}, {2, 3}}, hintNodes())
+}
+
+func TestCalculateHintsWithNUMAReservedCPUs(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithNUMAReservedCPUs")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	// NUMA 0 reserves all its 4 cores, and the others reserve nothing
+	dynamicPolicy.numaReservedCPUs = map[int]machine.CPUSet{
+		0: machine.NewCPUSet(0, 1, 8, 9),
+		1: machine.NewCPUSet(),
+		2: machine.NewCPUSet(),
+		3: machine.NewCPUSet(),
+	}
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	hints, err := dynamicPolicy.calculateHints(4, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+	as.Nil(err)
+
+	preferred := make([][]uint64, 0)
+	for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+		if hint.Preferred {
+			preferred = append(preferred, hint.Nodes)
+		}
+	}
+	// NUMA 1 has no reserved cpu in it now, so it can hold the request alone
+	as.Equal([][]uint64{{1}, {2}, {3}}, preferred)
+
+	as.Equal(machine.NewCPUSet(0, 1, 8, 9), dynamicPolicy.getNUMAReservedCPUs(0))
+	as.Equal(machine.NewCPUSet(), dynamicPolicy.getNUMAReservedCPUs(1))
+}
+
+func TestSimulateHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestSimulateHints")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	podUID := string(uuid.NewUUID())
+	newReq := func(qosLevel string, reqInt float64) *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          qosLevel,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: qosLevel,
+			},
+		}
+	}
+
+	// shared_cores has no NUMA preference
+	res, err := dynamicPolicy.SimulateHints(newReq(consts.PodAnnotationQoSLevelSharedCores, 2))
+	as.Nil(err)
+	as.Nil(res.Hints)
+	as.Empty(res.Reason)
+
+	req := newReq(consts.PodAnnotationQoSLevelDedicatedCores, 2)
+	req.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	// existing allocation regenerates its own hints
+	res, err = dynamicPolicy.SimulateHints(newReq(consts.PodAnnotationQoSLevelDedicatedCores, 2))
+	as.Nil(err)
+	as.Len(res.Hints, 1)
+	as.Equal([]uint64{2}, res.Hints[0].Nodes)
+
+	// existing allocation can't hold the larger request, and it's only discarded in the simulation
+	res, err = dynamicPolicy.SimulateHints(newReq(consts.PodAnnotationQoSLevelDedicatedCores, 5))
+	as.Nil(err)
+	as.NotEmpty(res.Hints)
+	as.Empty(res.Reason)
+	as.NotNil(dynamicPolicy.state.GetAllocationInfo(podUID, "test"))
+
+	res, err = dynamicPolicy.SimulateHints(newReq(consts.PodAnnotationQoSLevelDedicatedCores, 100))
+	as.Nil(err)
+	as.Empty(res.Hints)
+	as.NotEmpty(res.Reason)
+
+	// hints are adjusted by NUMA hint of scheduler in the same way as GetTopologyHints
+	req = newReq(consts.PodAnnotationQoSLevelDedicatedCores, 2)
+	req.PodUid = string(uuid.NewUUID())
+	req.Annotations[cpuconsts.PodAnnotationNUMAHintKey] = "3"
+	res, err = dynamicPolicy.SimulateHints(req)
+	as.Nil(err)
+	preferred := make([][]uint64, 0)
+	for _, hint := range res.Hints {
+		if hint.Preferred {
+			preferred = append(preferred, hint.Nodes)
+		}
+	}
+	as.Equal([][]uint64{{3}}, preferred)
+}
+
+func TestGetTopologyHintsWithNUMAHint(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestGetTopologyHintsWithNUMAHint")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	emitter := &recordedMetricsEmitter{}
+	dynamicPolicy.emitter = emitter
+
+	getPreferred := func(numaHint string) [][]uint64 {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+				cpuconsts.PodAnnotationNUMAHintKey:       numaHint,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+		as.Nil(err)
+
+		preferred := make([][]uint64, 0)
+		for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].Hints {
+			if hint.Preferred {
+				preferred = append(preferred, hint.Nodes)
+			}
+		}
+		return preferred
+	}
+
+	as.Equal([][]uint64{{3}}, getPreferred("3"))
+	as.NotContains(emitter.values, fmt.Sprintf("%s{}", util.MetricNameNUMAHintMismatch))
+	for _, phase := range []string{hintsCalculationPhaseTotal, hintsCalculationPhaseTopology,
+		hintsCalculationPhaseFilter, hintsCalculationPhaseSort} {
+		as.Contains(emitter.values, fmt.Sprintf("%s{numaCount=4,phase=%s}", util.MetricNameHintsCalculationDuration, phase))
+	}
+
+	// NUMA 5 doesn't exist, so calculated hints are kept
+	as.Equal([][]uint64{{0}, {1}, {2}, {3}}, getPreferred("5"))
+	as.Equal(int64(1), emitter.values[fmt.Sprintf("%s{}", util.MetricNameNUMAHintMismatch)])
+}
+
+func TestGetTopologyHintsWithNUMABindingUnsupported(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestGetTopologyHintsWithNUMABindingUnsupported")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.numaBindingUnsupportedErr = fmt.Errorf("no NUMA node is detected")
+
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.ErrorContains(err, "NUMA binding isn't supported")
+}
+
+func TestGetTopologyHintsWithCoordinatedNUMA(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestGetTopologyHintsWithCoordinatedNUMA")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	getHints := func(reqInt float64, coordinatedNUMA string) ([]*pluginapi.TopologyHint, error) {
+		resp, err := dynamicPolicy.GetTopologyHints(context.Background(), &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:           consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey:  `{"numa_binding": "true", "numa_exclusive": "true"}`,
+				cpuconsts.PodAnnotationCoordinatedNUMAKey: coordinatedNUMA,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.ResourceHints[string(v1.ResourceCPU)].Hints, nil
+	}
+
+	// non-preferred hint is used as long as it's feasible
+	hints, err := getHints(2, "2-3")
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2, 3}, Preferred: true}}, hints)
+
+	// NUMA 0 can't hold 6 cpus alone
+	_, err = getHints(6, "0")
+	as.NotNil(err)
+
+	_, err = getHints(2, "invalid")
+	as.NotNil(err)
+}
+
+func TestSidecarOwnCPUsHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestSidecarOwnCPUsHints")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	podUID := string(uuid.NewUUID())
+	newReq := func(containerType pluginapi.ContainerType, reqInt float64, sidecarOwnCPUs bool) *pluginapi.ResourceRequest {
+		req := &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  containerType.String(),
+			ContainerType:  containerType,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+		if sidecarOwnCPUs {
+			req.Annotations[cpuconsts.PodAnnotationSidecarOwnCPUsKey] = cpuconsts.PodAnnotationSidecarOwnCPUsEnable
+		}
+		return req
+	}
+
+	// main container isn't allocated yet
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq(pluginapi.ContainerType_SIDECAR, 1, true))
+	as.Nil(err)
+	as.Nil(resp.ResourceHints[string(v1.ResourceCPU)])
+
+	req := newReq(pluginapi.ContainerType_MAIN, 2, false)
+	req.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(pluginapi.ContainerType_SIDECAR, 1, false))
+	as.Nil(err)
+	as.Nil(resp.ResourceHints[string(v1.ResourceCPU)])
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(pluginapi.ContainerType_SIDECAR, 1, true))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2}, Preferred: true}}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// only 2 cpus are left in NUMA 2
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(pluginapi.ContainerType_SIDECAR, 3, true))
+	as.NotNil(err)
+
+	// the sidecar gets cpus of its own in NUMA 2 instead of cpus of the main container
+	sidecarReq := newReq(pluginapi.ContainerType_SIDECAR, 2, true)
+	sidecarReq.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), sidecarReq)
+	as.Nil(err)
+
+	mainAllocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, pluginapi.ContainerType_MAIN.String())
+	sidecarAllocationInfo := dynamicPolicy.state.GetAllocationInfo(podUID, pluginapi.ContainerType_SIDECAR.String())
+	as.NotNil(mainAllocationInfo)
+	as.NotNil(sidecarAllocationInfo)
+	as.Equal(2, sidecarAllocationInfo.AllocationResult.Size())
+	as.True(sidecarAllocationInfo.AllocationResult.IsSubsetOf(cpuTopology.CPUDetails.CPUsInNUMANodes(2)))
+	as.True(sidecarAllocationInfo.AllocationResult.Intersection(mainAllocationInfo.AllocationResult).IsEmpty())
+	as.Equal(0, dynamicPolicy.state.GetMachineState()[2].GetAvailableCPUSet(dynamicPolicy.reservedCPUs).Size())
+
+	// hints of the allocated sidecar are regenerated from its own cpus
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(pluginapi.ContainerType_SIDECAR, 2, true))
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{{Nodes: []uint64{2}, Preferred: true}}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+}
+
+func TestSidecarOwnCPUsWithNUMAExclusiveMainContainer(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestSidecarOwnCPUsWithNUMAExclusiveMainContainer")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	podUID := string(uuid.NewUUID())
+	newReq := func(containerType pluginapi.ContainerType, reqInt float64, sidecarOwnCPUs bool) *pluginapi.ResourceRequest {
+		req := &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  containerType.String(),
+			ContainerType:  containerType,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+		if sidecarOwnCPUs {
+			req.Annotations[cpuconsts.PodAnnotationSidecarOwnCPUsKey] = cpuconsts.PodAnnotationSidecarOwnCPUsEnable
+		}
+		return req
+	}
+
+	// numa_exclusive main container takes all cpus in its NUMA nodes, so the pod is rejected at hint time
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(pluginapi.ContainerType_MAIN, 2, true))
+	as.NotNil(err)
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(pluginapi.ContainerType_SIDECAR, 1, true))
+	as.NotNil(err)
+
+	// the main container is allocated without the annotation, then sidecars can't take cpus of their own
+	req := newReq(pluginapi.ContainerType_MAIN, 2, false)
+	req.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+
+	sidecarReq := newReq(pluginapi.ContainerType_SIDECAR, 1, true)
+	sidecarReq.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), sidecarReq)
+	as.NotNil(err)
+	as.Nil(dynamicPolicy.state.GetAllocationInfo(podUID, pluginapi.ContainerType_SIDECAR.String()))
+
+	// sidecars sharing cpus of the main container are still supported
+	sidecarReq = newReq(pluginapi.ContainerType_SIDECAR, 1, false)
+	sidecarReq.Hint = &pluginapi.TopologyHint{
+		Nodes:     []uint64{2},
+		Preferred: true,
+	}
+	_, err = dynamicPolicy.Allocate(context.Background(), sidecarReq)
+	as.Nil(err)
+	as.Equal(cpuTopology.CPUDetails.CPUsInNUMANodes(2),
+		dynamicPolicy.state.GetAllocationInfo(podUID, pluginapi.ContainerType_SIDECAR.String()).AllocationResult)
+}
+
+func TestReportReclaimedDisplacement(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestReportReclaimedDisplacement")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	newReq := func(reqInt float64, numaExclusive bool) *pluginapi.ResourceRequest {
+		memoryEnhancement := `{"numa_binding": "true"}`
+		if numaExclusive {
+			memoryEnhancement = `{"numa_binding": "true", "numa_exclusive": "true"}`
+		}
+
+		return &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: memoryEnhancement,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	// disabled by default
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq(1, false))
+	as.Nil(err)
+	as.NotContains(resp.Annotations, cpuconsts.ResourceHintsAnnotationReclaimedDisplacementKey)
+
+	dynamicPolicy.enableReclaimedDisplacementReport = true
+	as.Equal(machine.NewCPUSet(1, 3, 9, 11),
+		dynamicPolicy.state.GetAllocationInfo(state.PoolNameReclaim, advisorapi.FakedContainerName).AllocationResult)
+
+	// reclaimed cpus displaced by numa_binding container are limited by its request
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(1, false))
+	as.Nil(err)
+	as.JSONEq(`{"0":1,"1":1,"2":0,"3":0}`, resp.Annotations[cpuconsts.ResourceHintsAnnotationReclaimedDisplacementKey])
+
+	// numa_exclusive container displaces all reclaimed cpus in its NUMA nodes
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(6, true))
+	as.Nil(err)
+	as.JSONEq(`{"0-1":4,"2-3":0,"0-2":4,"0-1,3":4,"0,2-3":2,"1-3":2,"0-3":4}`,
+		resp.Annotations[cpuconsts.ResourceHintsAnnotationReclaimedDisplacementKey])
+}
+
+func TestReportHintRejections(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestReportHintRejections")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	emitter := &recordedMetricsEmitter{}
+	dynamicPolicy.emitter = emitter
+
+	newReq := func(reqInt float64) *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	// no rejections are reported if there is any hint
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq(6))
+	as.Nil(err)
+	as.NotContains(resp.Annotations, cpuconsts.ResourceHintsAnnotationHintRejectionsKey)
+
+	// reserved cpus 0 and 2 make the whole machine unable to hold 15 cpus
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(15))
+	as.Nil(err)
+	as.Empty(resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	rejections := make(map[string]string)
+	as.Nil(json.Unmarshal([]byte(resp.Annotations[cpuconsts.ResourceHintsAnnotationHintRejectionsKey]), &rejections))
+	as.Len(rejections, 15)
+	as.Equal(cpuconsts.HintRejectionReasonInsufficientNUMAs, rejections["0-2"])
+	as.Equal(cpuconsts.HintRejectionReasonInsufficientCPUs, rejections["0-3"])
+
+	// rejections are collected without emitting metrics of hints calculation once more
+	as.Equal(2, emitter.counts[fmt.Sprintf("%s{numaCount=4,phase=%s}",
+		util.MetricNameHintsCalculationDuration, hintsCalculationPhaseTotal)])
+
+	// rejections are carried by the error if hints calculation fails
+	dynamicPolicy.namespaceNUMABudget = map[string]int{"test": 0}
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(2))
+	as.NotNil(err)
+	as.Contains(err.Error(), cpuconsts.HintRejectionReasonNamespaceNUMABudget)
+
+	dynamicPolicy.namespaceNUMABudget = nil
+	req := newReq(6)
+	req.Annotations[cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinKey] = cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinEnable
+	_, err = dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.NotNil(err)
+	as.Contains(err.Error(), cpuconsts.HintRejectionReasonSingleNUMAOnly)
+}
+
+func TestGetTopologyHintsWithHintTrace(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestGetTopologyHintsWithHintTrace")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	newReq := func(traced bool) *pluginapi.ResourceRequest {
+		req := &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+		if traced {
+			req.Annotations[cpuconsts.PodAnnotationHintTraceKey] = cpuconsts.PodAnnotationHintTraceEnable
+		}
+		return req
+	}
+
+	// trace is off by default
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq(false))
+	as.Nil(err)
+	as.NotContains(resp.Annotations, cpuconsts.ResourceHintsAnnotationHintTraceKey)
+
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq(true))
+	as.Nil(err)
+
+	trace := &hintTrace{}
+	as.Nil(json.Unmarshal([]byte(resp.Annotations[cpuconsts.ResourceHintsAnnotationHintTraceKey]), trace))
+	as.Equal(hintTraceSourceCalculated, trace.Source)
+	as.Equal(2, trace.Request)
+	// reserved cpu 0 and 2 leave NUMA 0 and 1 with 3 available cpus, and the others have 4
+	as.Equal(map[int]int{0: 3, 1: 3, 2: 4, 3: 4}, trace.AvailableCPUs)
+	// all multi-NUMA masks are rejected for numa_binding but not exclusive container
+	as.Len(trace.Rejections, 11)
+	as.Equal(cpuconsts.HintRejectionReasonSingleNUMAOnly, trace.Rejections["0-1"])
+	as.Equal([]*hintTraceHint{
+		{Nodes: "0", Preferred: true},
+		{Nodes: "1", Preferred: true},
+		{Nodes: "2", Preferred: true},
+		{Nodes: "3", Preferred: true},
+	}, trace.Hints)
+	as.Equal("0", trace.Best)
+	as.False(trace.Truncated)
+
+	// trace is bounded in size
+	trace = &hintTrace{Rejections: make(hintRejections)}
+	for i := 0; i < maxHintTraceEntries+10; i++ {
+		trace.Rejections.record([]int{i}, cpuconsts.HintRejectionReasonInsufficientCPUs)
+	}
+	resp = &pluginapi.ResourceHintsResponse{}
+	trace.report(general.LoggerWithPrefix(t.Name(), general.LoggingPKGFull),
+		[]*pluginapi.TopologyHint{{Nodes: []uint64{0}, Preferred: true}}, resp)
+	as.Len(trace.Hints, 1)
+	as.Len(trace.Rejections, maxHintTraceEntries-1)
+	as.True(trace.Truncated)
+	as.Contains(resp.Annotations, cpuconsts.ResourceHintsAnnotationHintTraceKey)
+}
+
+func TestGetPodTopologyHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestGetPodTopologyHints")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	podUID := string(uuid.NewUUID())
+	newReq := func(containerName string, containerType pluginapi.ContainerType, reqInt float64,
+		sidecarOwnCPUs bool) *pluginapi.ResourceRequest {
+		req := &pluginapi.ResourceRequest{
+			PodUid:         podUID,
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  containerName,
+			ContainerType:  containerType,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): reqInt,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+		if sidecarOwnCPUs {
+			req.Annotations[cpuconsts.PodAnnotationSidecarOwnCPUsKey] = cpuconsts.PodAnnotationSidecarOwnCPUsEnable
+		}
+		return req
+	}
+
+	preferredNodes := func(resp *pluginapi.ResourceHintsResponse) [][]uint64 {
+		res := make([][]uint64, 0)
+		for _, hint := range resp.ResourceHints[string(v1.ResourceCPU)].GetHints() {
+			if hint.Preferred {
+				res = append(res, hint.Nodes)
+			}
+		}
+		return res
+	}
+
+	// the main container alone fits into any NUMA, but the whole pod only fits into NUMAs without reserved cpus
+	resps, err := dynamicPolicy.GetPodTopologyHints(context.Background(), []*pluginapi.ResourceRequest{
+		newReq("main", pluginapi.ContainerType_MAIN, 2, true),
+		newReq("sidecar", pluginapi.ContainerType_SIDECAR, 2, true),
+	})
+	as.Nil(err)
+	as.Len(resps, 2)
+	as.Equal([][]uint64{{2}, {3}}, preferredNodes(resps["main"]))
+	as.Equal(resps["main"].ResourceHints, resps["sidecar"].ResourceHints)
+	as.Equal("sidecar", resps["sidecar"].ContainerName)
+
+	// sidecars sharing cpus of the main container have no numa preference, and the main container is alone
+	resps, err = dynamicPolicy.GetPodTopologyHints(context.Background(), []*pluginapi.ResourceRequest{
+		newReq("main", pluginapi.ContainerType_MAIN, 3, false),
+		newReq("sidecar", pluginapi.ContainerType_SIDECAR, 2, false),
+	})
+	as.Nil(err)
+	as.Equal([][]uint64{{0}, {1}, {2}, {3}}, preferredNodes(resps["main"]))
+	as.Nil(resps["sidecar"].ResourceHints[string(v1.ResourceCPU)])
+
+	// sidecars of numa_exclusive pod can't get cpus of their own, so they aren't sized jointly and the pod is rejected
+	exclusiveReqs := []*pluginapi.ResourceRequest{
+		newReq("main", pluginapi.ContainerType_MAIN, 2, true),
+		newReq("sidecar", pluginapi.ContainerType_SIDECAR, 2, true),
+	}
+	for _, req := range exclusiveReqs {
+		req.Annotations[consts.PodAnnotationMemoryEnhancementKey] = `{"numa_binding": "true", "numa_exclusive": "true"}`
+	}
+	jointResps, err := dynamicPolicy.getJointTopologyHints(context.Background(), exclusiveReqs[0], exclusiveReqs)
+	as.Nil(err)
+	as.Nil(jointResps)
+	_, err = dynamicPolicy.GetPodTopologyHints(context.Background(), exclusiveReqs)
+	as.NotNil(err)
+
+	otherReq := newReq("other", pluginapi.ContainerType_SIDECAR, 2, false)
+	otherReq.PodUid = string(uuid.NewUUID())
+	_, err = dynamicPolicy.GetPodTopologyHints(context.Background(), []*pluginapi.ResourceRequest{
+		newReq("main", pluginapi.ContainerType_MAIN, 3, false), otherReq,
+	})
+	as.NotNil(err)
+}
+
+type fakeMemoryNUMAAvailabilityProvider struct {
+	availableNUMAs machine.CPUSet
+}
+
+func (f *fakeMemoryNUMAAvailabilityProvider) MemoryAvailableInNUMAs(numaNodes []int, _ uint64, _ map[string]string) bool {
+	return machine.NewCPUSet(numaNodes...).IsSubsetOf(f.availableNUMAs)
+}
+
+func TestAlignHintsWithMemory(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestAlignHintsWithMemory")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	newReq := func() *pluginapi.ResourceRequest {
+		return &pluginapi.ResourceRequest{
+			PodUid:         string(uuid.NewUUID()),
+			PodNamespace:   "test",
+			PodName:        "test",
+			ContainerName:  "test",
+			ContainerType:  pluginapi.ContainerType_MAIN,
+			ContainerIndex: 0,
+			ResourceName:   string(v1.ResourceCPU),
+			ResourceRequests: map[string]float64{
+				string(v1.ResourceCPU): 2,
+			},
+			Annotations: map[string]string{
+				consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+				consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+			},
+			Labels: map[string]string{
+				consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+			},
+		}
+	}
+
+	// memory resource plugin isn't registered
+	dynamicPolicy.getMemoryNUMAAvailabilityProvider = func() util.MemoryNUMAAvailabilityProvider { return nil }
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// only memory of NUMA 2 and 3 can satisfy the request
+	dynamicPolicy.getMemoryNUMAAvailabilityProvider = func() util.MemoryNUMAAvailabilityProvider {
+		return &fakeMemoryNUMAAvailabilityProvider{availableNUMAs: machine.NewCPUSet(2, 3)}
+	}
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+		{Nodes: []uint64{0}, Preferred: false},
+		{Nodes: []uint64{1}, Preferred: false},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// order of the configured strategy is kept among hints aligned with memory
+	allocateReq := newReq()
+	allocateReq.Hint = &pluginapi.TopologyHint{Nodes: []uint64{2}, Preferred: true}
+	_, err = dynamicPolicy.Allocate(context.Background(), allocateReq)
+	as.Nil(err)
+
+	dynamicPolicy.hintPreferenceStrategy, err = resolveHintPreferenceStrategy("", cpuconsts.CPUNUMAAllocationStrategySpread)
+	as.Nil(err)
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{3}, Preferred: true},
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{0}, Preferred: false},
+		{Nodes: []uint64{1}, Preferred: false},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	// the promoted hint isn't downgraded by memory again
+	dynamicPolicy.enableNonPreferredHintPromotion = true
+	dynamicPolicy.getMemoryNUMAAvailabilityProvider = func() util.MemoryNUMAAvailabilityProvider {
+		return &fakeMemoryNUMAAvailabilityProvider{availableNUMAs: machine.NewCPUSet()}
+	}
+	resp, err = dynamicPolicy.GetTopologyHints(context.Background(), newReq())
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{1}, Preferred: false},
+		{Nodes: []uint64{2}, Preferred: false},
+		{Nodes: []uint64{3}, Preferred: false},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+}
+
+func TestCalculateHintsWithFullPCPUsOnly(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithFullPCPUsOnly")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	hintNodes := func(reqAnnotations map[string]string) [][]uint64 {
+		hints, err := dynamicPolicy.calculateHints(3, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+		as.Nil(err)
+
+		res := make([][]uint64, 0)
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			res = append(res, hint.Nodes)
+		}
+		return res
+	}
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+	as.Equal([][]uint64{{0}, {1}, {2}, {3}}, hintNodes(reqAnnotations))
+
+	// reserved cpu 0 and 2 break one core in NUMA 0 and 1 respectively,
+	// so they only have 2 cpus of full physical cores for the request rounded up to 4
+	reqAnnotations[cpuconsts.PodAnnotationFullPCPUsOnlyKey] = cpuconsts.PodAnnotationFullPCPUsOnlyEnable
+	as.Equal([][]uint64{{2}, {3}}, hintNodes(reqAnnotations))
+}
+
+func TestCalculateHintsWithNilNUMAState(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithNilNUMAState")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+
+	// NUMA absent from topology is skipped
+	machineState := dynamicPolicy.state.GetMachineState()
+	machineState[4] = &state.NUMANodeState{}
+	hints, err := dynamicPolicy.calculateHints(2, "test", machineState, reqAnnotations)
+	as.Nil(err)
+	as.Len(hints[string(v1.ResourceCPU)].Hints, 4)
+
+	// NUMA present in topology but nil in state is reported as error
+	machineState = dynamicPolicy.state.GetMachineState()
+	machineState[1] = nil
+	_, err = dynamicPolicy.calculateHints(2, "test", machineState, reqAnnotations)
+	as.NotNil(err)
+}
+
+func TestCalculateHintsByTopology(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+
+	newMachineState := func(allocatedNUMAs ...int) state.NUMANodeMap {
+		machineState, err := state.GenerateMachineStateFromPodEntries(cpuTopology, nil, cpuconsts.CPUResourcePluginPolicyNameDynamic)
+		require.NoError(t, err)
+
+		for _, numaID := range allocatedNUMAs {
+			machineState[numaID].AllocatedCPUSet = cpuTopology.CPUDetails.CPUsInNUMANodes(numaID)
+			machineState[numaID].DefaultCPUSet = machine.NewCPUSet()
+		}
+		return machineState
+	}
+
+	numaBinding := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+	numaExclusive := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	tests := []struct {
+		name           string
+		reqInt         int
+		machineState   state.NUMANodeMap
+		reqAnnotations map[string]string
+		wantHints      []*pluginapi.TopologyHint
+		wantErr        bool
+	}{
+		{
+			name:         "masks crossing sockets are skipped unless more NUMAs than a socket are needed",
+			reqInt:       2,
+			machineState: newMachineState(),
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{2}, Preferred: true},
+				{Nodes: []uint64{3}, Preferred: true},
+				{Nodes: []uint64{0, 1}, Preferred: false},
+				{Nodes: []uint64{2, 3}, Preferred: false},
+				{Nodes: []uint64{0, 1, 2}, Preferred: false},
+				{Nodes: []uint64{0, 1, 3}, Preferred: false},
+				{Nodes: []uint64{0, 2, 3}, Preferred: false},
+				{Nodes: []uint64{1, 2, 3}, Preferred: false},
+				{Nodes: []uint64{0, 1, 2, 3}, Preferred: false},
+			},
+		},
+		{
+			name:           "numa_exclusive container skips allocated NUMAs",
+			reqInt:         6,
+			machineState:   newMachineState(1),
+			reqAnnotations: numaExclusive,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2, 3}, Preferred: true},
+				{Nodes: []uint64{0, 2, 3}, Preferred: false},
+			},
+		},
+		{
+			name:           "numa_binding container only gets single NUMA hints",
+			reqInt:         3,
+			machineState:   newMachineState(3),
+			reqAnnotations: numaBinding,
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{0}, Preferred: true},
+				{Nodes: []uint64{1}, Preferred: true},
+				{Nodes: []uint64{2}, Preferred: true},
+			},
+		},
+		{
+			name:         "strict single NUMA memory pin container only gets single NUMA hints",
+			reqInt:       4,
+			machineState: newMachineState(3),
+			reqAnnotations: map[string]string{
+				consts.PodAnnotationMemoryEnhancementNumaBinding:    consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+				consts.PodAnnotationMemoryEnhancementNumaExclusive:  consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+				cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinKey: cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinEnable,
+			},
+			wantHints: []*pluginapi.TopologyHint{
+				{Nodes: []uint64{2}, Preferred: true},
+			},
+		},
+		{
+			name:         "strict single NUMA memory pin container larger than 1 NUMA",
+			reqInt:       5,
+			machineState: newMachineState(),
+			reqAnnotations: map[string]string{
+				cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinKey: cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinEnable,
+			},
+			wantErr: true,
+		},
+		{
+			name:           "numa_binding container larger than 1 NUMA",
+			reqInt:         5,
+			machineState:   newMachineState(),
+			reqAnnotations: numaBinding,
+			wantErr:        true,
+		},
+		{
+			name:   "NUMA with nil state",
+			reqInt: 2,
+			machineState: func() state.NUMANodeMap {
+				machineState := newMachineState()
+				machineState[2] = nil
+				return machineState
+			}(),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			hints, _, _, err := calculateHintsByTopology(general.LoggerWithPrefix(tt.name, general.LoggingPKGFull), tt.reqInt, tt.machineState, cpuTopology,
+				machine.NewCPUSet(0, 2), 2, "", tt.reqAnnotations, nil, nil)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantHints, hints)
+		})
+	}
+}
+
+func TestCalculateHintsByTopologyOnSingleNUMA(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(8, 1, 1)
+	require.NoError(t, err)
+
+	machineState, err := state.GenerateMachineStateFromPodEntries(cpuTopology, nil, cpuconsts.CPUResourcePluginPolicyNameDynamic)
+	require.NoError(t, err)
+
+	logger := general.LoggerWithPrefix(t.Name(), general.LoggingPKGFull)
+	hints, _, _, err := calculateHintsByTopology(logger, 4, machineState, cpuTopology, machine.NewCPUSet(0), 1, "", nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []*pluginapi.TopologyHint{{Nodes: []uint64{0}, Preferred: true}}, hints)
+
+	// reserved cpus make the only NUMA unable to hold the request
+	rejections := make(hintRejections)
+	hints, _, _, err = calculateHintsByTopology(logger, 8, machineState, cpuTopology, machine.NewCPUSet(0), 1, "", nil, rejections, nil)
+	require.NoError(t, err)
+	require.Empty(t, hints)
+	require.Equal(t, hintRejections{"0": cpuconsts.HintRejectionReasonInsufficientCPUs}, rejections)
+}
+
+func TestCalculateHintsByTopologyWithAsymmetricNUMAs(t *testing.T) {
+	t.Parallel()
+
+	// 3 NUMA nodes with 16, 8 and 8 cpus in one socket
+	cpuTopology := &machine.CPUTopology{
+		NumCPUs:      32,
+		NumCores:     32,
+		NumSockets:   1,
+		NumNUMANodes: 3,
+		CPUDetails:   machine.CPUDetails{},
+	}
+	for cpuID := 0; cpuID < 32; cpuID++ {
+		numaID := 0
+		if cpuID >= 16 {
+			numaID = cpuID/8 - 1
+		}
+		cpuTopology.CPUDetails[cpuID] = machine.CPUInfo{NUMANodeID: numaID, CoreID: cpuID}
+	}
+
+	machineState, err := state.GenerateMachineStateFromPodEntries(cpuTopology, nil, cpuconsts.CPUResourcePluginPolicyNameDynamic)
+	require.NoError(t, err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	// only the largest NUMA can hold the request by itself
+	hints, _, _, err := calculateHintsByTopology(general.LoggerWithPrefix(t.Name(), general.LoggingPKGFull), 12, machineState, cpuTopology,
+		machine.NewCPUSet(), 3, "", reqAnnotations, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []*pluginapi.TopologyHint{
+		{Nodes: []uint64{0}, Preferred: true},
+		{Nodes: []uint64{0, 1}, Preferred: false},
+		{Nodes: []uint64{0, 2}, Preferred: false},
+		{Nodes: []uint64{1, 2}, Preferred: false},
+		{Nodes: []uint64{0, 1, 2}, Preferred: false},
+	}, hints)
+
+	// the two small NUMAs together can't hold the request
+	hints, _, _, err = calculateHintsByTopology(general.LoggerWithPrefix(t.Name(), general.LoggingPKGFull), 20, machineState, cpuTopology,
+		machine.NewCPUSet(), 3, "", reqAnnotations, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []*pluginapi.TopologyHint{
+		{Nodes: []uint64{0, 1}, Preferred: true},
+		{Nodes: []uint64{0, 2}, Preferred: true},
+		{Nodes: []uint64{0, 1, 2}, Preferred: false},
+	}, hints)
+}
+
+func TestCalculateHintsWithNUMADistances(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithNUMADistances")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(32, 2, 8)
+	as.Nil(err)
+
+	// sub-NUMA clustering: NUMA 2k and 2k+1 are closer to each other in the same socket
+	cpuTopology.NUMADistances = make(map[int]map[int]int)
+	for from := 0; from < 8; from++ {
+		cpuTopology.NUMADistances[from] = make(map[int]int)
+		for to := 0; to < 8; to++ {
+			switch {
+			case from == to:
+				cpuTopology.NUMADistances[from][to] = 10
+			case from/2 == to/2:
+				cpuTopology.NUMADistances[from][to] = 11
+			case from/4 == to/4:
+				cpuTopology.NUMADistances[from][to] = 12
+			default:
+				cpuTopology.NUMADistances[from][to] = 20
+			}
+		}
+	}
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+	getPreferred := func(memoryAvailable func(maskBits []int) bool) [][]uint64 {
+		hints, err := dynamicPolicy.calculateHintsWithRejections(general.LoggerWithPrefix(t.Name(), general.LoggingPKGFull),
+			6, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations, nil, memoryAvailable)
+		as.Nil(err)
+
+		var preferred [][]uint64
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			if hint.Preferred {
+				preferred = append(preferred, hint.Nodes)
+			}
+		}
+		return preferred
+	}
+
+	as.Equal([][]uint64{{0, 1}, {2, 3}, {4, 5}, {6, 7}}, getPreferred(nil))
+
+	// the nearest pairs can't satisfy memory, so the nearest ones of the rest are preferred
+	as.Equal([][]uint64{{0, 2}, {0, 3}, {1, 2}, {1, 3}, {4, 6}, {4, 7}, {5, 6}, {5, 7}},
+		getPreferred(func(maskBits []int) bool {
+			return len(maskBits) != 2 || maskBits[0]/2 != maskBits[1]/2
+		}))
+}
+
+func TestCalculateHintsExactOrder(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsExactOrder")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{1},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+	}
+
+	// NUMA 1 is taken, so socket 1 is less occupied and preferred by the balance strategy;
+	// the remaining hints follow by NUMA count and then by NUMA ids.
+	expectedHints := []*pluginapi.TopologyHint{
+		{Nodes: []uint64{2}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+		{Nodes: []uint64{0}, Preferred: false},
+		{Nodes: []uint64{2, 3}, Preferred: false},
+		{Nodes: []uint64{0, 2, 3}, Preferred: false},
+	}
+	for i := 0; i < 10; i++ {
+		hints, err := dynamicPolicy.calculateHints(2, "test", dynamicPolicy.state.GetMachineState(), reqAnnotations)
+		as.Nil(err)
+		as.Equal(expectedHints, hints[string(v1.ResourceCPU)].Hints)
+	}
+}
+
+func TestGetTopologyHintsAndAllocateForWholeMachine(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestGetTopologyHintsAndAllocateForWholeMachine")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.enableStrictNUMAExclusiveHints = true
+
+	// all cpus except reserved cpu 0 and 2
+	req := &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 14,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true", "numa_exclusive": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	}
+
+	resp, err := dynamicPolicy.GetTopologyHints(context.Background(), req)
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0, 1, 2, 3}, Preferred: true},
+	}, resp.ResourceHints[string(v1.ResourceCPU)].Hints)
+
+	req.Hint = resp.ResourceHints[string(v1.ResourceCPU)].Hints[0]
+	allocationResp, err := dynamicPolicy.Allocate(context.Background(), req)
+	as.Nil(err)
+	as.Equal(machine.NewCPUSet(1, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15).String(),
+		allocationResp.AllocationResult.ResourceAllocation[string(v1.ResourceCPU)].AllocationResult)
+
+	// reserved cpus in each NUMA make 3 NUMAs the minimal count needed by topology,
+	// but only the whole machine can hold the request
+	machineState, err := state.GenerateMachineStateFromPodEntries(cpuTopology, nil, cpuconsts.CPUResourcePluginPolicyNameDynamic)
+	as.Nil(err)
+	hints, _, _, err := calculateHintsByTopology(general.LoggerWithPrefix(t.Name(), general.LoggingPKGFull), 12, machineState, cpuTopology,
+		machine.NewCPUSet(0, 2, 4, 6), 2, "", map[string]string{
+			consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+		}, nil, nil)
+	as.Nil(err)
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{0, 1, 2, 3}, Preferred: true},
+	}, hints)
+}
+
+func TestCalculateHintsWithNUMAAllocationStrategy(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithNUMAAllocationStrategy")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	hintNodes := func(strategy string) [][]uint64 {
+		var err error
+		dynamicPolicy.hintPreferenceStrategy, err = resolveHintPreferenceStrategy(cpuconsts.CPUHintPreferenceStrategyMinNUMAs, strategy)
+		as.Nil(err)
+		hints, err := dynamicPolicy.calculateHints(2, "test", dynamicPolicy.state.GetMachineState(), map[string]string{
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		})
+		as.Nil(err)
+
+		res := make([][]uint64, 0)
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			as.True(hint.Preferred)
+			res = append(res, hint.Nodes)
+		}
+		return res
+	}
+
+	// reserved cpu 0 and 2 leave NUMA 0 and 1 with 3 available cpus, and the others have 4
+	as.Equal([][]uint64{{0}, {1}, {2}, {3}}, hintNodes(""))
+	as.Equal([][]uint64{{0}, {1}, {2}, {3}}, hintNodes(cpuconsts.CPUNUMAAllocationStrategyBinPacking))
+	as.Equal([][]uint64{{2}, {3}, {0}, {1}}, hintNodes(cpuconsts.CPUNUMAAllocationStrategySpread))
+
+	_, err = resolveHintPreferenceStrategy("", "unknown")
+	as.NotNil(err)
+	// NUMA allocation strategy can't override another hint preference strategy silently
+	_, err = resolveHintPreferenceStrategy(cpuconsts.CPUHintPreferenceStrategyLoadBalancing,
+		cpuconsts.CPUNUMAAllocationStrategyBinPacking)
+	as.NotNil(err)
+	strategy, err := resolveHintPreferenceStrategy(cpuconsts.CPUHintPreferenceStrategyLoadBalancing, "")
+	as.Nil(err)
+	as.Equal(loadBalancingHintPreferenceStrategy{}, strategy)
+}
+
+func TestCalculateHintsWithHintPreferenceStrategy(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithHintPreferenceStrategy")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	// pack into socket 0 where the NUMA-binding pod is
+	dynamicPolicy.socketSelectionStrategy = cpuconsts.CPUSocketSelectionStrategyPack
+
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{0},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	preferredNodes := func(name string) [][]uint64 {
+		dynamicPolicy.hintPreferenceStrategy, err = GetHintPreferenceStrategy(name)
+		as.Nil(err)
+
+		hints, err := dynamicPolicy.calculateHints(1, "test", dynamicPolicy.state.GetMachineState(), map[string]string{
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+		})
+		as.Nil(err)
+
+		res := make([][]uint64, 0)
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			if hint.Preferred {
+				res = append(res, hint.Nodes)
+			}
+		}
+		return res
+	}
+
+	// NUMA 0 has 2 of its 3 non-reserved cpus allocated, while NUMA 1 has none
+	as.Equal([][]uint64{{0}, {1}}, preferredNodes(""))
+	as.Equal([][]uint64{{0}, {1}}, preferredNodes(cpuconsts.CPUHintPreferenceStrategyMinNUMAs))
+	as.Equal([][]uint64{{1}, {0}}, preferredNodes(cpuconsts.CPUHintPreferenceStrategyLoadBalancing))
+
+	_, err = GetHintPreferenceStrategy("unknown")
+	as.NotNil(err)
+}
+
+func TestCalculateHintsWithBurstReservedNUMAs(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithBurstReservedNUMAs")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{0},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:          consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey: `{"numa_binding": "true"}`,
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	reqAnnotations := map[string]string{
+		consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+	}
+
+	// NUMA 1 is the least allocated one in socket 0, and NUMA 2 wins the tie with NUMA 3 in socket 1
+	dynamicPolicy.burstReservedNUMAsPerSocket = 1
+	machineState := dynamicPolicy.state.GetMachineState()
+	as.Equal(machine.NewCPUSet(1, 2), dynamicPolicy.getBurstReservedNUMAs(machineState))
+
+	for i := 0; i < 10; i++ {
+		hints, err := dynamicPolicy.calculateHints(1, "test", machineState, reqAnnotations)
+		as.Nil(err)
+		as.Equal([]*pluginapi.TopologyHint{
+			{Nodes: []uint64{3}, Preferred: true},
+			{Nodes: []uint64{0}, Preferred: false},
+		}, hints[string(v1.ResourceCPU)].Hints)
+	}
+
+	// all NUMA nodes are reserved, so fall back to use them
+	dynamicPolicy.burstReservedNUMAsPerSocket = 2
+	hints, err := dynamicPolicy.calculateHints(1, "test", machineState, reqAnnotations)
+	as.Nil(err)
+	as.Len(hints[string(v1.ResourceCPU)].Hints, 4)
+}
+
+func TestCalculateHintsWithCrossSocketPolicy(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithCrossSocketPolicy")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+
+	hintNodes := func(policy string, reqInt int) [][]uint64 {
+		dynamicPolicy.crossSocketPolicy = policy
+		hints, err := dynamicPolicy.calculateHints(reqInt, "test", dynamicPolicy.state.GetMachineState(), map[string]string{
+			consts.PodAnnotationMemoryEnhancementNumaBinding:   consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			consts.PodAnnotationMemoryEnhancementNumaExclusive: consts.PodAnnotationMemoryEnhancementNumaExclusiveEnable,
+		})
+		as.Nil(err)
+
+		res := make([][]uint64, 0)
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			res = append(res, hint.Nodes)
+		}
+		return res
+	}
+
+	// NUMA 0-1 are in socket 0 and NUMA 2-3 are in socket 1, and 6 cpus need 2 NUMAs
+	for _, policy := range []string{"", cpuconsts.CPUCrossSocketPolicyPreferSameSocket} {
+		as.Equal([][]uint64{{0, 1}, {2, 3}, {0, 1, 2}, {0, 1, 3}, {0, 2, 3}, {1, 2, 3}, {0, 1, 2, 3}}, hintNodes(policy, 6))
+		as.Equal([][]uint64{{0, 1, 2, 3}}, hintNodes(policy, 14))
+	}
+
+	as.Equal([][]uint64{{0, 1}, {2, 3}}, hintNodes(cpuconsts.CPUCrossSocketPolicyForbidCrossSocket, 6))
+	as.Empty(hintNodes(cpuconsts.CPUCrossSocketPolicyForbidCrossSocket, 14))
+
+	as.Equal([][]uint64{{0, 1}, {0, 2}, {0, 3}, {1, 2}, {1, 3}, {2, 3},
+		{0, 1, 2}, {0, 1, 3}, {0, 2, 3}, {1, 2, 3}, {0, 1, 2, 3}}, hintNodes(cpuconsts.CPUCrossSocketPolicyAllowCrossSocket, 6))
+	as.Equal([][]uint64{{0, 1, 2, 3}}, hintNodes(cpuconsts.CPUCrossSocketPolicyAllowCrossSocket, 14))
+}
+
+func TestCalculateHintsWithMemoryBandwidthBudget(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint_TestCalculateHintsWithMemoryBandwidthBudget")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, tmpDir)
+	as.Nil(err)
+	dynamicPolicy.numaMemoryBandwidthBudget = 10000
+	// pack into socket 1 where the NUMA-binding pod is
+	dynamicPolicy.socketSelectionStrategy = cpuconsts.CPUSocketSelectionStrategyPack
+
+	_, err = dynamicPolicy.Allocate(context.Background(), &pluginapi.ResourceRequest{
+		PodUid:         string(uuid.NewUUID()),
+		PodNamespace:   "test",
+		PodName:        "test",
+		ContainerName:  "test",
+		ContainerType:  pluginapi.ContainerType_MAIN,
+		ContainerIndex: 0,
+		ResourceName:   string(v1.ResourceCPU),
+		ResourceRequests: map[string]float64{
+			string(v1.ResourceCPU): 2,
+		},
+		Hint: &pluginapi.TopologyHint{
+			Nodes:     []uint64{2},
+			Preferred: true,
+		},
+		Annotations: map[string]string{
+			consts.PodAnnotationQoSLevelKey:           consts.PodAnnotationQoSLevelDedicatedCores,
+			consts.PodAnnotationMemoryEnhancementKey:  `{"numa_binding": "true"}`,
+			cpuconsts.PodAnnotationMemoryBandwidthKey: "6000",
+		},
+		Labels: map[string]string{
+			consts.PodAnnotationQoSLevelKey: consts.PodAnnotationQoSLevelDedicatedCores,
+		},
+	})
+	as.Nil(err)
+
+	machineState := dynamicPolicy.state.GetMachineState()
+	usage := getNUMAMemoryBandwidthUsage(machineState)
+	as.Equal(int64(6000), usage[2])
+	as.Equal(int64(0), usage[3])
+
+	getPreferred := func(memoryBandwidth string) [][]uint64 {
+		hints, err := dynamicPolicy.calculateHints(2, "test", machineState, map[string]string{
+			consts.PodAnnotationMemoryEnhancementNumaBinding: consts.PodAnnotationMemoryEnhancementNumaBindingEnable,
+			cpuconsts.PodAnnotationMemoryBandwidthKey:        memoryBandwidth,
+		})
+		as.Nil(err)
+
+		preferred := make([][]uint64, 0)
+		for _, hint := range hints[string(v1.ResourceCPU)].Hints {
+			if hint.Preferred {
+				preferred = append(preferred, hint.Nodes)
+			}
+		}
+		return preferred
+	}
+
+	as.Equal([][]uint64{{2}, {3}}, getPreferred("4000"))
+	// NUMA 2 has only 4000 left in its budget
+	as.Equal([][]uint64{{3}}, getPreferred("5000"))
+}
@@ -18,6 +18,7 @@ package state
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -178,8 +179,10 @@ func GenerateMachineStateFromPodEntries(topology *machine.CPUTopology, podEntrie
 
 					switch policyName {
 					case consts.CPUResourcePluginPolicyNameDynamic:
-						// only modify allocated and default properties in NUMA node state if the policy is dynamic and the QoS class is dedicated_cores with NUMA binding
-						if CheckDedicatedNUMABinding(allocationInfo) {
+						// only modify allocated and default properties in NUMA node state if the policy is dynamic and the QoS class is dedicated_cores
+						// with NUMA binding, or dedicated_cores without NUMA binding which has been isolated into dedicated pool,
+						// so that cpus isolated for the latter won't be allocated to NUMA binding containers any more
+						if CheckDedicatedNUMABinding(allocationInfo) || (CheckDedicated(allocationInfo) && CheckDedicatedPool(allocationInfo)) {
 							allocatedCPUsInNumaNode = allocatedCPUsInNumaNode.Union(allocationInfo.OriginalTopologyAwareAssignments[int(numaNode)])
 						}
 					case consts.CPUResourcePluginPolicyNameNative:
@@ -210,6 +213,22 @@ func GenerateMachineStateFromPodEntries(topology *machine.CPUTopology, podEntrie
 	return machineState, nil
 }
 
+// GetMemoryBandwidthFromAnnotations returns memory bandwidth reserved in the given annotations,
+// and zero is returned if it's not specified or invalid.
+func GetMemoryBandwidthFromAnnotations(annotations map[string]string) int64 {
+	val, ok := annotations[consts.PodAnnotationMemoryBandwidthKey]
+	if !ok {
+		return 0
+	}
+
+	memoryBandwidth, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || memoryBandwidth < 0 {
+		klog.Errorf("[GetMemoryBandwidthFromAnnotations] invalid memory bandwidth: %s", val)
+		return 0
+	}
+	return memoryBandwidth
+}
+
 func IsIsolationPool(poolName string) bool {
 	return strings.HasPrefix(poolName, PoolNamePrefixIsolation)
 }
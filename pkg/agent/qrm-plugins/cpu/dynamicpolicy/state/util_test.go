@@ -560,3 +560,43 @@ func TestGetSpecifiedPoolName(t *testing.T) {
 		})
 	}
 }
+
+func TestGetMemoryBandwidthFromAnnotations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        int64
+	}{
+		{
+			name: "memory bandwidth not specified",
+			want: 0,
+		},
+		{
+			name:        "invalid memory bandwidth",
+			annotations: map[string]string{cpuconsts.PodAnnotationMemoryBandwidthKey: "invalid"},
+			want:        0,
+		},
+		{
+			name:        "negative memory bandwidth",
+			annotations: map[string]string{cpuconsts.PodAnnotationMemoryBandwidthKey: "-1"},
+			want:        0,
+		},
+		{
+			name:        "valid memory bandwidth",
+			annotations: map[string]string{cpuconsts.PodAnnotationMemoryBandwidthKey: "6000"},
+			want:        6000,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := GetMemoryBandwidthFromAnnotations(tt.annotations); got != tt.want {
+				t.Errorf("GetMemoryBandwidthFromAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
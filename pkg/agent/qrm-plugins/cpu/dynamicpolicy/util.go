@@ -26,6 +26,7 @@ import (
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
 	"github.com/kubewharf/katalyst-core/pkg/util/general"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+	qosutil "github.com/kubewharf/katalyst-core/pkg/util/qos"
 )
 
 func getProportionalSize(oldPoolSize, oldTotalSize, newTotalSize int) int {
@@ -36,6 +37,141 @@ func generateMachineStateFromPodEntries(topology *machine.CPUTopology, podEntrie
 	return state.GenerateMachineStateFromPodEntries(topology, podEntries, cpuconsts.CPUResourcePluginPolicyNameDynamic)
 }
 
+// pluginAnnotationKeys are cpu plugin specific annotations, they should be kept
+// since GetKatalystQoSLevelFromResourceReq only keeps katalyst QoS related annotations.
+var pluginAnnotationKeys = []string{
+	cpuconsts.PodAnnotationNUMAHintKey,
+	cpuconsts.PodAnnotationSidecarOwnCPUsKey,
+	cpuconsts.PodAnnotationFullPCPUsOnlyKey,
+	cpuconsts.PodAnnotationMemoryBandwidthKey,
+	cpuconsts.PodAnnotationStrictSingleNUMAMemoryPinKey,
+	cpuconsts.PodAnnotationSharedPoolNUMAPreferenceKey,
+	cpuconsts.PodAnnotationCoordinatedNUMAKey,
+	cpuconsts.PodAnnotationHintTraceKey,
+}
+
+// getPluginAnnotations returns cpu plugin specific annotations in the given annotations
+func getPluginAnnotations(annotations map[string]string) map[string]string {
+	pluginAnnotations := make(map[string]string)
+	for _, key := range pluginAnnotationKeys {
+		if val, ok := annotations[key]; ok {
+			pluginAnnotations[key] = val
+		}
+	}
+	return pluginAnnotations
+}
+
+// checkSidecarOwnCPUs returns true if sidecars of the pod with the given annotations opt into cpus of their own
+// in NUMA nodes of the main container, instead of sharing cpuset of the main container.
+func checkSidecarOwnCPUs(annotations map[string]string) bool {
+	return annotations[cpuconsts.PodAnnotationSidecarOwnCPUsKey] == cpuconsts.PodAnnotationSidecarOwnCPUsEnable
+}
+
+// checkSidecarOwnCPUsSupported returns error if sidecars opt into cpus of their own while the main container
+// is numa_exclusive, since it takes all cpus in its NUMA nodes, and no cpu is left for sidecars there.
+func checkSidecarOwnCPUsSupported(annotations map[string]string) error {
+	if checkSidecarOwnCPUs(annotations) && qosutil.AnnotationsIndicateNUMAExclusive(annotations) {
+		return fmt.Errorf("%s isn't supported for numa_exclusive pod, please remove either of them",
+			cpuconsts.PodAnnotationSidecarOwnCPUsKey)
+	}
+	return nil
+}
+
+// checkNUMABindingCapability returns error if the machine topology can't support NUMA binding,
+// e.g. NUMA nodes or sockets aren't detected, or NUMA nodes can't be evenly divided into sockets.
+func checkNUMABindingCapability(topology *machine.CPUTopology) error {
+	if topology == nil {
+		return fmt.Errorf("cpu topology isn't detected")
+	} else if topology.NumNUMANodes < 1 || topology.CPUDetails.NUMANodes().IsEmpty() {
+		return fmt.Errorf("no NUMA node is detected")
+	} else if topology.NumSockets < 1 || topology.CPUDetails.Sockets().IsEmpty() {
+		return fmt.Errorf("no socket is detected")
+	}
+
+	if _, err := topology.NUMAsPerSocket(); err != nil {
+		return fmt.Errorf("NUMAsPerSocket failed with error: %v", err)
+	}
+	return nil
+}
+
+// getHintLogger returns the logger attributing logs of hints calculation to the given container request
+func getHintLogger(req *pluginapi.ResourceRequest, reqInt int) general.Logger {
+	return general.LoggerWithPrefix(fmt.Sprintf("podNamespace=%s podName=%s containerName=%s requestedCPU=%d",
+		req.PodNamespace, req.PodName, req.ContainerName, reqInt), general.LoggingPKGFull)
+}
+
+// getNamespaceNUMAUsage returns NUMA nodes occupied by NUMA-binding pods of each namespace
+func getNamespaceNUMAUsage(machineState state.NUMANodeMap) map[string]machine.CPUSet {
+	usage := make(map[string]machine.CPUSet)
+	for numaID, numaState := range machineState {
+		if numaState == nil {
+			continue
+		}
+
+		for _, containerEntries := range numaState.PodEntries {
+			for _, allocationInfo := range containerEntries {
+				if allocationInfo == nil || !state.CheckNUMABinding(allocationInfo) {
+					continue
+				}
+
+				if _, ok := usage[allocationInfo.PodNamespace]; !ok {
+					usage[allocationInfo.PodNamespace] = machine.NewCPUSet()
+				}
+				usage[allocationInfo.PodNamespace].Add(numaID)
+			}
+		}
+	}
+	return usage
+}
+
+// getNUMAMemoryBandwidthUsage returns memory bandwidth reserved by dedicated_cores with NUMA binding
+// in each NUMA node, the reservation of a container is split evenly among all of its NUMA nodes.
+func getNUMAMemoryBandwidthUsage(machineState state.NUMANodeMap) map[int]int64 {
+	// NUMA node states only hold per-NUMA parts of allocations, so count NUMA nodes of each container first
+	containerNUMAs := make(map[string]map[string]machine.CPUSet)
+	for numaID, numaState := range machineState {
+		if numaState == nil {
+			continue
+		}
+
+		for podUID, containerEntries := range numaState.PodEntries {
+			for containerName, allocationInfo := range containerEntries {
+				// sidecars share the reservation of the main container
+				if allocationInfo == nil || !state.CheckDedicatedNUMABinding(allocationInfo) ||
+					!allocationInfo.CheckMainContainer() {
+					continue
+				}
+
+				if _, ok := containerNUMAs[podUID]; !ok {
+					containerNUMAs[podUID] = make(map[string]machine.CPUSet)
+				}
+				if _, ok := containerNUMAs[podUID][containerName]; !ok {
+					containerNUMAs[podUID][containerName] = machine.NewCPUSet()
+				}
+				containerNUMAs[podUID][containerName].Add(numaID)
+			}
+		}
+	}
+
+	usage := make(map[int]int64, len(machineState))
+	for numaID, numaState := range machineState {
+		if numaState == nil {
+			continue
+		}
+
+		for podUID, containerNUMASets := range containerNUMAs {
+			for containerName, numaSet := range containerNUMASets {
+				allocationInfo := numaState.PodEntries[podUID][containerName]
+				if allocationInfo == nil {
+					continue
+				}
+				usage[numaID] += state.GetMemoryBandwidthFromAnnotations(allocationInfo.Annotations) / int64(numaSet.Size())
+			}
+		}
+	}
+	return usage
+}
+
 // updateAllocationInfoByReq updates allocationInfo by latest req when admitting active pod,
 // because qos level and annotations will change after we support customized updater of enhancements and qos level
 func updateAllocationInfoByReq(req *pluginapi.ResourceRequest, allocationInfo *state.AllocationInfo) error {
@@ -19,10 +19,12 @@ package dynamicpolicy
 import (
 	"testing"
 
+	"github.com/stretchr/testify/require"
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 
 	apiconsts "github.com/kubewharf/katalyst-api/pkg/consts"
 	"github.com/kubewharf/katalyst-core/pkg/agent/qrm-plugins/cpu/dynamicpolicy/state"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
 
 func Test_updateAllocationInfoByReq(t *testing.T) {
@@ -65,3 +67,30 @@ func Test_updateAllocationInfoByReq(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckNUMABindingCapability(t *testing.T) {
+	t.Parallel()
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	require.NoError(t, err)
+	require.NoError(t, checkNUMABindingCapability(cpuTopology))
+
+	cpuTopology, err = machine.GenerateDummyCPUTopology(4, 1, 1)
+	require.NoError(t, err)
+	require.NoError(t, checkNUMABindingCapability(cpuTopology))
+
+	require.Error(t, checkNUMABindingCapability(nil))
+	require.Error(t, checkNUMABindingCapability(&machine.CPUTopology{NumCPUs: 4, NumSockets: 1}))
+
+	// 3 NUMA nodes can't be evenly divided into 2 sockets
+	require.Error(t, checkNUMABindingCapability(&machine.CPUTopology{
+		NumCPUs:      3,
+		NumSockets:   2,
+		NumNUMANodes: 3,
+		CPUDetails: machine.CPUDetails{
+			0: {NUMANodeID: 0, SocketID: 0, CoreID: 0},
+			1: {NUMANodeID: 1, SocketID: 0, CoreID: 1},
+			2: {NUMANodeID: 2, SocketID: 1, CoreID: 2},
+		},
+	}))
+}
@@ -78,6 +78,45 @@ func GetCoresReservedForSystem(conf *config.Configuration, metaServer *metaserve
 	return reservedCPUs, nil
 }
 
+// GetCoresReservedForSystemPerNUMA returns the reserved cpus of each NUMA node, NUMA nodes
+// in ReservedCPUCoresPerNUMA take the configured count of cpus by topology from themselves,
+// while the others fall back to their parts of the given machine-wide reservedCPUs.
+func GetCoresReservedForSystemPerNUMA(conf *config.Configuration, machineInfo *machine.KatalystMachineInfo,
+	reservedCPUs machine.CPUSet) (map[int]machine.CPUSet, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("nil conf")
+	} else if machineInfo == nil {
+		return nil, fmt.Errorf("nil machineInfo")
+	}
+
+	numaReservedCPUs := make(map[int]machine.CPUSet, machineInfo.NumNUMANodes)
+	for numaID := 0; numaID < machineInfo.NumNUMANodes; numaID++ {
+		numaCPUs := machineInfo.CPUDetails.CPUsInNUMANodes(numaID)
+
+		reservedQuantityInt, ok := conf.ReservedCPUCoresPerNUMA[numaID]
+		if !ok {
+			numaReservedCPUs[numaID] = reservedCPUs.Intersection(numaCPUs)
+			continue
+		}
+
+		numaReserved, err := calculator.TakeByTopology(machineInfo, numaCPUs, reservedQuantityInt)
+		if err != nil {
+			return nil, fmt.Errorf("TakeByTopology for reservedCPUsNum: %d in NUMA: %d failed with error: %v",
+				reservedQuantityInt, numaID, err)
+		}
+
+		general.Infof("take reservedCPUs: %s in NUMA: %d by reservedCPUsNum: %d", numaReserved.String(), numaID, reservedQuantityInt)
+		numaReservedCPUs[numaID] = numaReserved
+	}
+
+	for numaID := range conf.ReservedCPUCoresPerNUMA {
+		if _, ok := numaReservedCPUs[numaID]; !ok {
+			return nil, fmt.Errorf("reserved cpu cores are configured for invalid NUMA: %d", numaID)
+		}
+	}
+	return numaReservedCPUs, nil
+}
+
 // RegenerateHints regenerates hints for container that'd already been allocated cpu,
 // and regenerateHints will assemble hints based on already-existed AllocationInfo,
 // without any calculation logics at all
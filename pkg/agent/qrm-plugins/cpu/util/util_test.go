@@ -129,6 +129,106 @@ func TestGetCoresReservedForSystem(t *testing.T) {
 	}
 }
 
+func TestGetCoresReservedForSystemPerNUMA(t *testing.T) {
+	t.Parallel()
+
+	topology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	assert.Nil(t, err)
+	machineInfo := &machine.KatalystMachineInfo{
+		CPUTopology: topology,
+	}
+
+	newConf := func(reservedCPUCoresPerNUMA map[int]int) *config.Configuration {
+		return &config.Configuration{
+			AgentConfiguration: &agent.AgentConfiguration{
+				StaticAgentConfiguration: &agent.StaticAgentConfiguration{
+					QRMPluginsConfiguration: &qrm.QRMPluginsConfiguration{
+						CPUQRMPluginConfig: &qrm.CPUQRMPluginConfig{
+							ReservedCPUCoresPerNUMA: reservedCPUCoresPerNUMA,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	type args struct {
+		conf         *config.Configuration
+		machineInfo  *machine.KatalystMachineInfo
+		reservedCPUs machine.CPUSet
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    map[int]machine.CPUSet
+		wantErr bool
+	}{
+		{
+			name:    "GetCoresReservedForSystemPerNUMA with nil conf",
+			wantErr: true,
+		},
+		{
+			name: "GetCoresReservedForSystemPerNUMA with nil machineInfo",
+			args: args{
+				conf: newConf(nil),
+			},
+			wantErr: true,
+		},
+		{
+			name: "GetCoresReservedForSystemPerNUMA falls back to machine-wide reserved cpus",
+			args: args{
+				conf:         newConf(nil),
+				machineInfo:  machineInfo,
+				reservedCPUs: machine.NewCPUSet(0, 2, 4, 6),
+			},
+			want: map[int]machine.CPUSet{
+				0: machine.NewCPUSet(0),
+				1: machine.NewCPUSet(2),
+				2: machine.NewCPUSet(4),
+				3: machine.NewCPUSet(6),
+			},
+		},
+		{
+			name: "GetCoresReservedForSystemPerNUMA with 4 cores reserved in NUMA 0",
+			args: args{
+				conf:         newConf(map[int]int{0: 4}),
+				machineInfo:  machineInfo,
+				reservedCPUs: machine.NewCPUSet(),
+			},
+			want: map[int]machine.CPUSet{
+				0: machine.NewCPUSet(0, 1, 8, 9),
+				1: machine.NewCPUSet(),
+				2: machine.NewCPUSet(),
+				3: machine.NewCPUSet(),
+			},
+		},
+		{
+			name: "GetCoresReservedForSystemPerNUMA with invalid NUMA",
+			args: args{
+				conf:         newConf(map[int]int{4: 1}),
+				machineInfo:  machineInfo,
+				reservedCPUs: machine.NewCPUSet(),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := GetCoresReservedForSystemPerNUMA(tt.args.conf, tt.args.machineInfo, tt.args.reservedCPUs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetCoresReservedForSystemPerNUMA() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetCoresReservedForSystemPerNUMA() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestRegenerateHints(t *testing.T) {
 	t.Parallel()
 
@@ -122,6 +122,7 @@ type DynamicPolicy struct {
 	enhancementHandlers util.ResourceEnhancementHandlerMap
 
 	extraStateFileAbsPath string
+	extraStateFileCache   *util.ExtraStateFileCache
 	name                  string
 
 	podDebugAnnoKeys []string
@@ -199,6 +200,10 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 		oomPriorityMapPinnedPath:   conf.OOMPriorityPinnedMapAbsPath,
 	}
 
+	if policyImplement.extraStateFileAbsPath != "" {
+		policyImplement.extraStateFileCache = util.NewExtraStateFileCache(policyImplement.extraStateFileAbsPath, wrappedEmitter)
+	}
+
 	policyImplement.allocationHandlers = map[string]util.AllocationHandler{
 		apiconsts.PodAnnotationQoSLevelSharedCores:    policyImplement.sharedCoresAllocationHandler,
 		apiconsts.PodAnnotationQoSLevelDedicatedCores: policyImplement.dedicatedCoresAllocationHandler,
@@ -229,6 +234,8 @@ func NewDynamicPolicy(agentCtx *agent.GenericContext, conf *config.Configuration
 	memoryadvisor.RegisterControlKnobHandler(memoryadvisor.ControlKnobKeyDropCache,
 		memoryadvisor.ControlKnobHandlerWithChecker(policyImplement.handleAdvisorDropCache))
 
+	util.RegisterMemoryNUMAAvailabilityProvider(policyImplement)
+
 	return true, &agent.PluginWrapper{GenericPlugin: pluginWrapper}, nil
 }
 
@@ -261,6 +268,13 @@ func (p *DynamicPolicy) Start() (err error) {
 	go wait.Until(p.applyExternalCgroupParams, applyCgroupPeriod, p.stopCh)
 	go wait.Until(p.setExtraControlKnobByConfigs, setExtraControlKnobsPeriod, p.stopCh)
 
+	// reload hints in extra state file once it's changed
+	if p.extraStateFileCache != nil {
+		if err := p.extraStateFileCache.Run(p.stopCh); err != nil {
+			return fmt.Errorf("run extra state file cache failed with error: %v", err)
+		}
+	}
+
 	if p.enableSettingMemoryMigrate {
 		general.Infof("setMemoryMigrate enabled")
 		go wait.Until(p.setMemoryMigrate, setMemoryMigratePeriod, p.stopCh)
@@ -108,12 +108,11 @@ func (p *DynamicPolicy) dedicatedCoresWithNUMABindingHintHandler(_ context.Conte
 	}
 
 	// if hints exists in extra state-file, prefer to use them
-	if hints == nil {
+	if hints == nil && p.extraStateFileCache != nil {
 		availableNUMAs := resourcesMachineState[v1.ResourceMemory].GetNUMANodesWithoutNUMABindingPods()
 
 		var extraErr error
-		hints, extraErr = util.GetHintsFromExtraStateFile(req.PodName, string(v1.ResourceMemory),
-			p.extraStateFileAbsPath, availableNUMAs)
+		hints, extraErr = p.extraStateFileCache.GetHintsFromExtraStateFile(req.PodName, string(v1.ResourceMemory), availableNUMAs)
 		if extraErr != nil {
 			general.Infof("pod: %s/%s, container: %s GetHintsFromExtraStateFile failed with error: %v",
 				req.PodNamespace, req.PodName, req.ContainerName, extraErr)
@@ -274,3 +273,25 @@ func regenerateHints(reqInt uint64, allocationInfo *state.AllocationInfo) map[st
 	}
 	return hints
 }
+
+// MemoryAvailableInNUMAs implements util.MemoryNUMAAvailabilityProvider, it returns whether free memory of
+// the given NUMA nodes is able to satisfy the request, and numa_exclusive container also needs all of them
+// to be not allocated; it's consulted by cpu resource plugin to align cpu hints with memory.
+func (p *DynamicPolicy) MemoryAvailableInNUMAs(numaNodes []int, reqBytes uint64, reqAnnotations map[string]string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	machineState := p.state.GetMachineState()[v1.ResourceMemory]
+
+	var freeBytes uint64 = 0
+	for _, numaID := range numaNodes {
+		if machineState[numaID] == nil {
+			return false
+		} else if qosutil.AnnotationsIndicateNUMAExclusive(reqAnnotations) && machineState[numaID].Allocated > 0 {
+			return false
+		}
+
+		freeBytes += machineState[numaID].Free
+	}
+	return freeBytes >= reqBytes
+}
@@ -1251,6 +1251,31 @@ func TestGetTopologyHints(t *testing.T) {
 	}
 }
 
+func TestMemoryAvailableInNUMAs(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestMemoryAvailableInNUMAs")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	machineInfo, err := machine.GenerateDummyMachineInfo(4, 32)
+	as.Nil(err)
+
+	dynamicPolicy, err := getTestDynamicPolicyWithInitialization(cpuTopology, machineInfo, tmpDir)
+	as.Nil(err)
+
+	// each NUMA has 7GB free memory after 1GB is reserved
+	as.True(dynamicPolicy.MemoryAvailableInNUMAs([]int{0}, 1<<30, nil))
+	as.False(dynamicPolicy.MemoryAvailableInNUMAs([]int{0}, 8<<30, nil))
+	as.True(dynamicPolicy.MemoryAvailableInNUMAs([]int{0, 1}, 12<<30, nil))
+	as.False(dynamicPolicy.MemoryAvailableInNUMAs([]int{4}, 0, nil))
+}
+
 func TestGetTopologyAwareAllocatableResources(t *testing.T) {
 	t.Parallel()
 
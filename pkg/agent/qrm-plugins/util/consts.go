@@ -33,6 +33,19 @@ const (
 	MetricNameCPUSetInvalid    = "cpuset_invalid"
 	MetricNameCPUSetOverlap    = "cpuset_overlap"
 
+	MetricNameStateInvariantViolated   = "state_invariant_violated"
+	MetricNameAllocationDiscarded      = "allocation_discarded"
+	MetricNameNUMAHintMismatch         = "numa_hint_mismatch"
+	MetricNameAllocationNUMAChanged    = "allocation_numa_changed"
+	MetricNameExtraStateFileInvalid    = "extra_state_file_invalid"
+	MetricNameHintsCalculationDuration = "hints_calculation_duration"
+
+	// per-NUMA metrics for cpu plugin
+	MetricNameNUMAAvailableCPUs = "numa_available_cpus"
+	MetricNameNUMAAllocatedCPUs = "numa_allocated_cpus"
+	MetricNameNUMAPodCount      = "numa_pod_count"
+	MetricNameNUMAExclusive     = "numa_exclusive"
+
 	// metrics for memory plugin
 	MetricNameMemSetInvalid                           = "memset_invalid"
 	MetricNameMemSetOverlap                           = "memset_overlap"
@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/apimachinery/pkg/util/wait"
+	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
+
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
+	"github.com/kubewharf/katalyst-core/pkg/util/general"
+	"github.com/kubewharf/katalyst-core/pkg/util/machine"
+)
+
+// extraStateFileReloadPeriod is the period to reload extra state file if its directory can't be watched
+const extraStateFileReloadPeriod = 30 * time.Second
+
+// ExtraStateFileCache caches the parsed extra state file (see GetHintsFromExtraStateFile),
+// and reloads it once the file is changed. if the changed file is invalid, the last valid
+// content is kept and an error metric is emitted, so that allocation won't be broken by it.
+type ExtraStateFileCache struct {
+	mutex sync.RWMutex
+
+	fileAbsPath  string
+	emitter      metrics.MetricEmitter
+	reloadPeriod time.Duration
+
+	memoryEntries map[string]string
+}
+
+// NewExtraStateFileCache returns a cache of the given extra state file, it loads the file right away
+func NewExtraStateFileCache(fileAbsPath string, emitter metrics.MetricEmitter) *ExtraStateFileCache {
+	c := &ExtraStateFileCache{
+		fileAbsPath:   fileAbsPath,
+		emitter:       emitter,
+		reloadPeriod:  extraStateFileReloadPeriod,
+		memoryEntries: make(map[string]string),
+	}
+
+	if err := c.Reload(); err != nil {
+		general.Errorf("load extra state file: %s failed with error: %v", fileAbsPath, err)
+	}
+	return c
+}
+
+// Run watches the directory of extra state file, and reloads the file once it's changed;
+// if the directory can't be watched (e.g. it doesn't exist yet), the file is reloaded periodically instead.
+func (c *ExtraStateFileCache) Run(stopCh <-chan struct{}) error {
+	fileDir := filepath.Dir(c.fileAbsPath)
+
+	// the watcher only logs the failure of adding a nonexistent directory, so check it beforehand
+	var watcherCh <-chan struct{}
+	_, err := os.Stat(fileDir)
+	if err == nil {
+		watcherCh, err = general.RegisterFileEventWatcher(stopCh, general.FileWatcherInfo{
+			Filename: filepath.Base(c.fileAbsPath),
+			Path:     []string{fileDir},
+			Op:       fsnotify.Create | fsnotify.Write | fsnotify.Rename | fsnotify.Remove,
+		})
+	}
+
+	if err != nil {
+		general.Warningf("watch extra state file: %s failed with error: %v, reload it every %v instead",
+			c.fileAbsPath, err, c.reloadPeriod)
+		go wait.Until(c.reload, c.reloadPeriod, stopCh)
+		return nil
+	}
+
+	go func() {
+		for range watcherCh {
+			c.reload()
+		}
+	}()
+	return nil
+}
+
+// reload works as Reload, but only logs the error
+func (c *ExtraStateFileCache) reload() {
+	if err := c.Reload(); err != nil {
+		general.Errorf("reload extra state file: %s failed with error: %v", c.fileAbsPath, err)
+	}
+}
+
+// Reload reads and validates the extra state file, and the cached content is replaced only
+// if the file is valid; a removed file clears the cached content.
+func (c *ExtraStateFileCache) Reload() error {
+	fileBytes, err := ioutil.ReadFile(c.fileAbsPath)
+	if os.IsNotExist(err) {
+		c.mutex.Lock()
+		c.memoryEntries = make(map[string]string)
+		c.mutex.Unlock()
+		return nil
+	} else if err != nil {
+		_ = c.emitter.StoreInt64(MetricNameExtraStateFileInvalid, 1, metrics.MetricTypeNameRaw)
+		return fmt.Errorf("read extra state file failed with error: %v", err)
+	}
+
+	memoryEntries, err := parseExtraStateFileMemoryEntries(fileBytes)
+	if err != nil {
+		_ = c.emitter.StoreInt64(MetricNameExtraStateFileInvalid, 1, metrics.MetricTypeNameRaw)
+		return fmt.Errorf("invalid extra state file, keep the last valid one: %v", err)
+	}
+
+	c.mutex.Lock()
+	c.memoryEntries = memoryEntries
+	c.mutex.Unlock()
+
+	general.Infof("extra state file: %s is loaded with %d memory entries", c.fileAbsPath, len(memoryEntries))
+	return nil
+}
+
+// GetHintsFromExtraStateFile works as the package-level GetHintsFromExtraStateFile, but reads the cached content
+func (c *ExtraStateFileCache) GetHintsFromExtraStateFile(podName, resourceName string,
+	availableNUMAs machine.CPUSet) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return getHintsFromExtraStateMemoryEntries(c.memoryEntries, podName, resourceName, availableNUMAs)
+}
@@ -18,6 +18,7 @@ package util
 
 import (
 	"context"
+	"sync"
 
 	pluginapi "k8s.io/kubelet/pkg/apis/resourceplugin/v1alpha1"
 
@@ -54,3 +55,32 @@ func (r ResourceEnhancementHandlerMap) Register(
 
 	r[phase][enhancementKey] = handler
 }
+
+// MemoryNUMAAvailabilityProvider is implemented by memory resource plugin, so that other
+// resource plugins are able to align their hints with NUMA nodes whose memory can satisfy the request
+type MemoryNUMAAvailabilityProvider interface {
+	// MemoryAvailableInNUMAs returns whether memory of the given NUMA nodes is able to satisfy
+	// the request bytes of container with the given annotations
+	MemoryAvailableInNUMAs(numaNodes []int, reqBytes uint64, reqAnnotations map[string]string) bool
+}
+
+var (
+	memoryNUMAAvailabilityProviderMtx sync.RWMutex
+	memoryNUMAAvailabilityProvider    MemoryNUMAAvailabilityProvider
+)
+
+// RegisterMemoryNUMAAvailabilityProvider registers the provider of memory NUMA availability,
+// it should be called by memory resource plugin when it's initialized
+func RegisterMemoryNUMAAvailabilityProvider(provider MemoryNUMAAvailabilityProvider) {
+	memoryNUMAAvailabilityProviderMtx.Lock()
+	defer memoryNUMAAvailabilityProviderMtx.Unlock()
+	memoryNUMAAvailabilityProvider = provider
+}
+
+// GetMemoryNUMAAvailabilityProvider returns the registered provider of memory NUMA availability,
+// and nil is returned if memory resource plugin isn't registered
+func GetMemoryNUMAAvailabilityProvider() MemoryNUMAAvailabilityProvider {
+	memoryNUMAAvailabilityProviderMtx.RLock()
+	defer memoryNUMAAvailabilityProviderMtx.RUnlock()
+	return memoryNUMAAvailabilityProvider
+}
@@ -61,6 +61,35 @@ func GetQuantityFromResourceReq(req *pluginapi.ResourceRequest) (int, error) {
 	return 0, fmt.Errorf("unexpected end")
 }
 
+// GetCPUQuantityFromResourceReq parses cpu quantity of the request into both of the rounded integer
+// and the original millicores. the rounded integer is always the ceil of the request (e.g. 2500m -> 3),
+// which is the same as GetQuantityFromResourceReq and is used for NUMA sizing, while the millicores
+// keep the fractional part of the request for anyone who needs the accurate value.
+func GetCPUQuantityFromResourceReq(req *pluginapi.ResourceRequest) (int, int64, error) {
+	if len(req.ResourceRequests) != 1 {
+		return 0, 0, fmt.Errorf("invalid req.ResourceRequests length: %d", len(req.ResourceRequests))
+	}
+
+	var milliCPUs float64
+	for key, val := range req.ResourceRequests {
+		switch key {
+		case string(v1.ResourceCPU):
+			milliCPUs = val * 1000.0
+		case string(apiconsts.ReclaimedResourceMilliCPU):
+			milliCPUs = val
+		default:
+			return 0, 0, fmt.Errorf("invalid cpu request resource name: %s", key)
+		}
+	}
+
+	reqInt, err := GetQuantityFromResourceReq(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	// negative requests are treated as zero, the same as GetQuantityFromResourceReq
+	return reqInt, general.MaxInt64(int64(math.Round(milliCPUs)), 0), nil
+}
+
 // IsDebugPod returns true if the pod annotations show up any configurable debug key
 func IsDebugPod(podAnnotations map[string]string, podDebugAnnoKeys []string) bool {
 	for _, debugKey := range podDebugAnnoKeys {
@@ -120,6 +149,30 @@ func HintToIntArray(hint *pluginapi.TopologyHint) []int {
 	return result
 }
 
+// SortTopologyHints sorts hints in place with a deterministic order:
+// preferred hints come first, then hints with fewer NUMA nodes,
+// and hints with the same NUMA count are ordered by ascending NUMA ids.
+// callers may reorder preferred hints with the same NUMA count afterwards by their own strategy,
+// e.g. the hint preference strategy of cpu plugin, which only moves hints within those groups.
+func SortTopologyHints(hints []*pluginapi.TopologyHint) {
+	sort.SliceStable(hints, func(i, j int) bool {
+		if hints[i].Preferred != hints[j].Preferred {
+			return hints[i].Preferred
+		}
+
+		if len(hints[i].Nodes) != len(hints[j].Nodes) {
+			return len(hints[i].Nodes) < len(hints[j].Nodes)
+		}
+
+		for k := range hints[i].Nodes {
+			if hints[i].Nodes[k] != hints[j].Nodes[k] {
+				return hints[i].Nodes[k] < hints[j].Nodes[k]
+			}
+		}
+		return false
+	})
+}
+
 // GetTopologyAwareQuantityFromAssignments returns TopologyAwareQuantity based on assignments
 func GetTopologyAwareQuantityFromAssignments(assignments map[int]machine.CPUSet) []*pluginapi.TopologyAwareQuantity {
 	if assignments == nil {
@@ -195,32 +248,92 @@ func PackResourceHintsResponse(req *pluginapi.ResourceRequest, resourceName stri
 }
 
 // GetNUMANodesCountToFitCPUReq is used to calculate the amount of numa nodes
-// we need if we try to allocate cpu cores among them, assuming that all numa nodes
-// contain the same cpu capacity
+// we need if we try to allocate cpu cores among them; NUMA nodes with the most cpus
+// are counted first, so that it also works for NUMA nodes with different cpu capacity
 func GetNUMANodesCountToFitCPUReq(cpuReq int, cpuTopology *machine.CPUTopology) (int, int, error) {
 	if cpuTopology == nil {
 		return 0, 0, fmt.Errorf("GetNumaNodesToFitCPUReq got nil cpuTopology")
 	}
 
-	numaCount := cpuTopology.CPUDetails.NUMANodes().Size()
-	if numaCount == 0 {
-		return 0, 0, fmt.Errorf("there is no NUMA in cpuTopology")
+	numaCountNeeded, err := GetNUMANodesCountToFitCPUReqWithReserved(cpuReq, cpuTopology, machine.NewCPUSet())
+	if err != nil {
+		return 0, 0, err
 	}
 
-	if cpuTopology.NumCPUs%numaCount != 0 {
-		return 0, 0, fmt.Errorf("invalid NUMAs count: %d with CPUs count: %d", numaCount, cpuTopology.NumCPUs)
+	cpusCountNeededPerNUMA := int(math.Ceil(float64(cpuReq) / float64(numaCountNeeded)))
+	return numaCountNeeded, cpusCountNeededPerNUMA, nil
+}
+
+// GetNUMANodesCountToFitCPUReqWithReserved is used to calculate the amount of numa nodes
+// we need if we try to allocate cpu cores among them, taking reserved cpus into consideration;
+// NUMA nodes with the most allocatable cpus are counted first, so the result is a lower bound.
+func GetNUMANodesCountToFitCPUReqWithReserved(cpuReq int, cpuTopology *machine.CPUTopology, reservedCPUs machine.CPUSet) (int, error) {
+	if cpuTopology == nil {
+		return 0, fmt.Errorf("GetNUMANodesCountToFitCPUReqWithReserved got nil cpuTopology")
+	} else if cpuReq <= 0 {
+		return 0, fmt.Errorf("invalid cpu req: %d", cpuReq)
 	}
 
-	cpusPerNUMA := cpuTopology.NumCPUs / numaCount
-	numaCountNeeded := int(math.Ceil(float64(cpuReq) / float64(cpusPerNUMA)))
-	if numaCountNeeded == 0 {
-		return 0, 0, fmt.Errorf("zero numaCountNeeded")
-	} else if numaCountNeeded > numaCount {
-		return 0, 0, fmt.Errorf("invalid cpu req: %d in topology with NUMAs count: %d and CPUs count: %d", cpuReq, numaCount, cpuTopology.NumCPUs)
+	numaNodes := cpuTopology.CPUDetails.NUMANodes().ToSliceInt()
+	if len(numaNodes) == 0 {
+		return 0, fmt.Errorf("there is no NUMA in cpuTopology")
 	}
 
-	cpusCountNeededPerNUMA := int(math.Ceil(float64(cpuReq) / float64(numaCountNeeded)))
-	return numaCountNeeded, cpusCountNeededPerNUMA, nil
+	allocatableCPUs := make([]int, 0, len(numaNodes))
+	for _, numaNode := range numaNodes {
+		allocatableCPUs = append(allocatableCPUs,
+			cpuTopology.CPUDetails.CPUsInNUMANodes(numaNode).Difference(reservedCPUs).Size())
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(allocatableCPUs)))
+
+	total := 0
+	for i, allocatable := range allocatableCPUs {
+		total += allocatable
+		if total >= cpuReq {
+			return i + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid cpu req: %d in topology with %d allocatable CPUs excluding reserved: %s",
+		cpuReq, total, reservedCPUs.String())
+}
+
+// GetNUMANodesCountToFitFullPCPUsReq is the variant of GetNUMANodesCountToFitCPUReq for
+// containers that only want full physical cores, so the request is rounded up to whole cores
+// before calculation; it also returns the rounded request.
+func GetNUMANodesCountToFitFullPCPUsReq(cpuReq int, cpuTopology *machine.CPUTopology) (int, int, error) {
+	if cpuTopology == nil {
+		return 0, 0, fmt.Errorf("GetNUMANodesCountToFitFullPCPUsReq got nil cpuTopology")
+	}
+
+	cpusPerCore := cpuTopology.CPUsPerCore()
+	if cpusPerCore == 0 {
+		return 0, 0, fmt.Errorf("there is no core in cpuTopology")
+	}
+
+	fullPCPUsReq := int(math.Ceil(float64(cpuReq)/float64(cpusPerCore))) * cpusPerCore
+	numaCountNeeded, _, err := GetNUMANodesCountToFitCPUReq(fullPCPUsReq, cpuTopology)
+	if err != nil {
+		return 0, 0, err
+	}
+	return numaCountNeeded, fullPCPUsReq, nil
+}
+
+// GetFullPCPUsInCPUSet returns cpus in the given cpuset whose hyper-thread siblings are all in it,
+// i.e. cpus of the sibling-complete physical cores.
+func GetFullPCPUsInCPUSet(cpuTopology *machine.CPUTopology, cpus machine.CPUSet) machine.CPUSet {
+	if cpuTopology == nil {
+		return machine.NewCPUSet()
+	}
+
+	res := machine.NewCPUSet()
+	for _, coreID := range cpuTopology.CPUDetails.KeepOnly(cpus).Cores().ToSliceInt() {
+		coreCPUs := cpuTopology.CPUDetails.CPUsInCores(coreID)
+		if coreCPUs.IsSubsetOf(cpus) {
+			res = res.Union(coreCPUs)
+		}
+	}
+	return res
 }
 
 // GetNUMANodesCountToFitMemoryReq is used to calculate the amount of numa nodes
@@ -267,25 +380,52 @@ func GetHintsFromExtraStateFile(podName, resourceName, extraHintsStateFileAbsPat
 		return nil, fmt.Errorf("read extra hints state file failed with error: %v", err)
 	}
 
+	memoryEntries, err := parseExtraStateFileMemoryEntries(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+	return getHintsFromExtraStateMemoryEntries(memoryEntries, podName, resourceName, availableNUMAs)
+}
+
+// parseExtraStateFileMemoryEntries parses content of the extra state file into memory entries,
+// keyed by pod name with suffix -0 and valued by NUMA nodes in cpuset format.
+func parseExtraStateFileMemoryEntries(fileBytes []byte) (map[string]string, error) {
 	extraState := make(map[string]interface{})
-	err = json.Unmarshal(fileBytes, &extraState)
+	err := json.Unmarshal(fileBytes, &extraState)
 	if err != nil {
 		return nil, fmt.Errorf("unmarshal extra state file content failed with error: %v", err)
 	}
 
-	memoryEntries, typeOk := extraState["memoryEntries"].(map[string]interface{})
+	rawMemoryEntries, typeOk := extraState["memoryEntries"].(map[string]interface{})
 	if !typeOk {
 		return nil, fmt.Errorf("memory entries with invalid type: %T", extraState["memoryEntries"])
 	}
 
-	extraPodName := fmt.Sprintf("%s-0", podName)
-	if memoryEntries[extraPodName] == nil {
-		return nil, fmt.Errorf("extra state file hasn't memory entry for pod: %s", extraPodName)
+	// malformed entries are skipped, so that they don't affect hints of other pods
+	memoryEntries := make(map[string]string, len(rawMemoryEntries))
+	for extraPodName, rawMemoryEntry := range rawMemoryEntries {
+		memoryEntry, typeOk := rawMemoryEntry.(string)
+		if !typeOk {
+			general.Warningf("skip memory entry of pod: %s with invalid type: %T", extraPodName, rawMemoryEntry)
+			continue
+		}
+
+		if _, err := machine.Parse(memoryEntry); err != nil {
+			general.Warningf("skip memory entry of pod: %s, parse %s failed with error: %v", extraPodName, memoryEntry, err)
+			continue
+		}
+		memoryEntries[extraPodName] = memoryEntry
 	}
+	return memoryEntries, nil
+}
 
-	memoryEntry, typeOk := memoryEntries[extraPodName].(string)
-	if !typeOk {
-		return nil, fmt.Errorf("memory entry with invalid type: %T", memoryEntries[extraPodName])
+// getHintsFromExtraStateMemoryEntries returns the hint of the pod in memory entries of the extra state file
+func getHintsFromExtraStateMemoryEntries(memoryEntries map[string]string, podName, resourceName string,
+	availableNUMAs machine.CPUSet) (map[string]*pluginapi.ListOfTopologyHints, error) {
+	extraPodName := fmt.Sprintf("%s-0", podName)
+	memoryEntry, ok := memoryEntries[extraPodName]
+	if !ok {
+		return nil, fmt.Errorf("extra state file hasn't memory entry for pod: %s", extraPodName)
 	}
 
 	numaSet, err := machine.Parse(memoryEntry)
@@ -18,7 +18,11 @@ package util
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
@@ -26,6 +30,7 @@ import (
 	"k8s.io/kubernetes/pkg/kubelet/cm/topologymanager/bitmask"
 
 	"github.com/kubewharf/katalyst-api/pkg/consts"
+	"github.com/kubewharf/katalyst-core/pkg/metrics"
 	"github.com/kubewharf/katalyst-core/pkg/util/machine"
 )
 
@@ -91,6 +96,75 @@ func TestGetQuantityFromResourceReq(t *testing.T) {
 	}
 }
 
+func TestGetCPUQuantityFromResourceReq(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	testCases := []struct {
+		req       *pluginapi.ResourceRequest
+		reqInt    int
+		milliCPUs int64
+		wantErr   bool
+	}{
+		{
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2.5,
+				},
+			},
+			reqInt:    3,
+			milliCPUs: 2500,
+		},
+		{
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): 2.3,
+				},
+			},
+			reqInt:    3,
+			milliCPUs: 2300,
+		},
+		{
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					string(consts.ReclaimedResourceMilliCPU): 4000,
+				},
+			},
+			reqInt:    4,
+			milliCPUs: 4000,
+		},
+		{
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceCPU): -1.5,
+				},
+			},
+			reqInt:    0,
+			milliCPUs: 0,
+		},
+		{
+			req: &pluginapi.ResourceRequest{
+				ResourceRequests: map[string]float64{
+					string(v1.ResourceMemory): 256,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		reqInt, milliCPUs, err := GetCPUQuantityFromResourceReq(tc.req)
+		if tc.wantErr {
+			as.NotNil(err)
+			continue
+		}
+		as.Nil(err)
+		as.Equal(tc.reqInt, reqInt)
+		as.Equal(tc.milliCPUs, milliCPUs)
+	}
+}
+
 func TestDeepCopyTopologyAwareAssignments(t *testing.T) {
 	t.Parallel()
 
@@ -166,6 +240,236 @@ func TestHintToIntArray(t *testing.T) {
 	}
 }
 
+func TestSortTopologyHints(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	hints := []*pluginapi.TopologyHint{
+		{Nodes: []uint64{0, 1}, Preferred: false},
+		{Nodes: []uint64{3}, Preferred: true},
+		{Nodes: []uint64{1, 2, 3}, Preferred: false},
+		{Nodes: []uint64{0, 2}, Preferred: false},
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{0, 1, 2}, Preferred: false},
+	}
+
+	SortTopologyHints(hints)
+
+	as.Equal([]*pluginapi.TopologyHint{
+		{Nodes: []uint64{1}, Preferred: true},
+		{Nodes: []uint64{3}, Preferred: true},
+		{Nodes: []uint64{0, 1}, Preferred: false},
+		{Nodes: []uint64{0, 2}, Preferred: false},
+		{Nodes: []uint64{0, 1, 2}, Preferred: false},
+		{Nodes: []uint64{1, 2, 3}, Preferred: false},
+	}, hints)
+}
+
+func TestGetNUMANodesCountToFitCPUReq(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	// 3 NUMA nodes with 16, 8 and 8 cpus in one socket
+	asymmetricTopology := &machine.CPUTopology{
+		NumCPUs:      32,
+		NumCores:     32,
+		NumSockets:   1,
+		NumNUMANodes: 3,
+		CPUDetails:   machine.CPUDetails{},
+	}
+	for cpuID := 0; cpuID < 32; cpuID++ {
+		numaID := 0
+		if cpuID >= 16 {
+			numaID = cpuID/8 - 1
+		}
+		asymmetricTopology.CPUDetails[cpuID] = machine.CPUInfo{NUMANodeID: numaID, CoreID: cpuID}
+	}
+
+	testCases := []struct {
+		description   string
+		cpuReq        int
+		cpuTopology   *machine.CPUTopology
+		expectedCount int
+		expectedErr   bool
+	}{
+		{
+			description:   "fits into one NUMA",
+			cpuReq:        4,
+			cpuTopology:   cpuTopology,
+			expectedCount: 1,
+		},
+		{
+			description:   "needs more NUMAs",
+			cpuReq:        6,
+			cpuTopology:   cpuTopology,
+			expectedCount: 2,
+		},
+		{
+			description:   "fits into the largest asymmetric NUMA",
+			cpuReq:        12,
+			cpuTopology:   asymmetricTopology,
+			expectedCount: 1,
+		},
+		{
+			description:   "needs the largest and one small asymmetric NUMA",
+			cpuReq:        24,
+			cpuTopology:   asymmetricTopology,
+			expectedCount: 2,
+		},
+		{
+			description:   "needs all asymmetric NUMAs",
+			cpuReq:        25,
+			cpuTopology:   asymmetricTopology,
+			expectedCount: 3,
+		},
+		{
+			description: "request larger than asymmetric topology",
+			cpuReq:      33,
+			cpuTopology: asymmetricTopology,
+			expectedErr: true,
+		},
+		{
+			description: "zero request",
+			cpuReq:      0,
+			cpuTopology: cpuTopology,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		count, _, err := GetNUMANodesCountToFitCPUReq(tc.cpuReq, tc.cpuTopology)
+		if tc.expectedErr {
+			as.NotNilf(err, "failed in test case: %s", tc.description)
+			continue
+		}
+		as.Nilf(err, "failed in test case: %s", tc.description)
+		as.Equalf(tc.expectedCount, count, "failed in test case: %s", tc.description)
+	}
+}
+
+func TestGetNUMANodesCountToFitCPUReqWithReserved(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	testCases := []struct {
+		description   string
+		cpuReq        int
+		reservedCPUs  machine.CPUSet
+		expectedCount int
+		expectedErr   bool
+	}{
+		{
+			description:   "fits into one NUMA without reserved cpus",
+			cpuReq:        4,
+			reservedCPUs:  machine.NewCPUSet(),
+			expectedCount: 1,
+		},
+		{
+			description:   "fits into one NUMA without reserved cpus in it",
+			cpuReq:        4,
+			reservedCPUs:  machine.NewCPUSet(0, 2),
+			expectedCount: 1,
+		},
+		{
+			description:   "needs more NUMAs because of reserved cpus",
+			cpuReq:        4,
+			reservedCPUs:  machine.NewCPUSet(0, 2, 4, 6),
+			expectedCount: 2,
+		},
+		{
+			description:  "request larger than allocatable cpus",
+			cpuReq:       15,
+			reservedCPUs: machine.NewCPUSet(0, 2),
+			expectedErr:  true,
+		},
+		{
+			description:  "zero request",
+			cpuReq:       0,
+			reservedCPUs: machine.NewCPUSet(),
+			expectedErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		count, err := GetNUMANodesCountToFitCPUReqWithReserved(tc.cpuReq, cpuTopology, tc.reservedCPUs)
+		if tc.expectedErr {
+			as.NotNilf(err, "failed in test case: %s", tc.description)
+			continue
+		}
+		as.Nilf(err, "failed in test case: %s", tc.description)
+		as.Equalf(tc.expectedCount, count, "failed in test case: %s", tc.description)
+	}
+}
+
+func TestGetNUMANodesCountToFitFullPCPUsReq(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	testCases := []struct {
+		description     string
+		cpuReq          int
+		expectedCount   int
+		expectedRounded int
+		expectedErr     bool
+	}{
+		{
+			description:     "request of whole cores",
+			cpuReq:          4,
+			expectedCount:   1,
+			expectedRounded: 4,
+		},
+		{
+			description:     "request rounded up to whole cores",
+			cpuReq:          5,
+			expectedCount:   2,
+			expectedRounded: 6,
+		},
+		{
+			description: "request larger than the machine",
+			cpuReq:      17,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		count, rounded, err := GetNUMANodesCountToFitFullPCPUsReq(tc.cpuReq, cpuTopology)
+		if tc.expectedErr {
+			as.NotNilf(err, "failed in test case: %s", tc.description)
+			continue
+		}
+		as.Nilf(err, "failed in test case: %s", tc.description)
+		as.Equalf(tc.expectedCount, count, "failed in test case: %s", tc.description)
+		as.Equalf(tc.expectedRounded, rounded, "failed in test case: %s", tc.description)
+	}
+}
+
+func TestGetFullPCPUsInCPUSet(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	cpuTopology, err := machine.GenerateDummyCPUTopology(16, 2, 4)
+	as.Nil(err)
+
+	// cpu 0 and 8 are siblings, so are 1 and 9
+	as.Equal(machine.NewCPUSet(1, 9), GetFullPCPUsInCPUSet(cpuTopology, machine.NewCPUSet(1, 8, 9)))
+	as.Equal(machine.NewCPUSet(0, 1, 8, 9), GetFullPCPUsInCPUSet(cpuTopology, machine.NewCPUSet(0, 1, 8, 9)))
+	as.Equal(machine.NewCPUSet(), GetFullPCPUsInCPUSet(cpuTopology, machine.NewCPUSet(0, 1)))
+}
+
 func TestMaskToUInt64Array(t *testing.T) {
 	t.Parallel()
 
@@ -250,3 +554,95 @@ func TestTransformTopologyAwareQuantity(t *testing.T) {
 		as.Equalf(tc.expectedQuantityList, actualQuantityList, "failed in test case: %s", tc.description)
 	}
 }
+
+func TestExtraStateFileCache(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestExtraStateFileCache")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	fileAbsPath := filepath.Join(tmpDir, "extra_state_file")
+	availableNUMAs := machine.NewCPUSet(0, 1, 2, 3)
+
+	as.Nil(ioutil.WriteFile(fileAbsPath, []byte(`{"memoryEntries":{"pod-0":"0-1"}}`), 0o644))
+	cache := NewExtraStateFileCache(fileAbsPath, metrics.DummyMetrics{})
+
+	hints, err := cache.GetHintsFromExtraStateFile("pod", string(v1.ResourceCPU), availableNUMAs)
+	as.Nil(err)
+	as.Equal([]uint64{0, 1}, hints[string(v1.ResourceCPU)].Hints[0].Nodes)
+
+	// invalid file keeps the last valid content
+	as.Nil(ioutil.WriteFile(fileAbsPath, []byte(`{"memoryEntries":`), 0o644))
+	as.NotNil(cache.Reload())
+
+	hints, err = cache.GetHintsFromExtraStateFile("pod", string(v1.ResourceCPU), availableNUMAs)
+	as.Nil(err)
+	as.Equal([]uint64{0, 1}, hints[string(v1.ResourceCPU)].Hints[0].Nodes)
+
+	// malformed entries are skipped without affecting others
+	as.Nil(ioutil.WriteFile(fileAbsPath, []byte(`{"memoryEntries":{"pod-0":"invalid","other-0":3,"another-0":"3"}}`), 0o644))
+	as.Nil(cache.Reload())
+
+	_, err = cache.GetHintsFromExtraStateFile("pod", string(v1.ResourceCPU), availableNUMAs)
+	as.NotNil(err)
+	_, err = cache.GetHintsFromExtraStateFile("other", string(v1.ResourceCPU), availableNUMAs)
+	as.NotNil(err)
+	hints, err = cache.GetHintsFromExtraStateFile("another", string(v1.ResourceCPU), availableNUMAs)
+	as.Nil(err)
+	as.Equal([]uint64{3}, hints[string(v1.ResourceCPU)].Hints[0].Nodes)
+
+	hints, err = GetHintsFromExtraStateFile("another", string(v1.ResourceCPU), fileAbsPath, availableNUMAs)
+	as.Nil(err)
+	as.Equal([]uint64{3}, hints[string(v1.ResourceCPU)].Hints[0].Nodes)
+
+	as.Nil(ioutil.WriteFile(fileAbsPath, []byte(`{"memoryEntries":{"pod-0":"2"}}`), 0o644))
+	as.Nil(cache.Reload())
+
+	hints, err = cache.GetHintsFromExtraStateFile("pod", string(v1.ResourceCPU), availableNUMAs)
+	as.Nil(err)
+	as.Equal([]uint64{2}, hints[string(v1.ResourceCPU)].Hints[0].Nodes)
+
+	// removed file clears the cached content
+	as.Nil(os.Remove(fileAbsPath))
+	as.Nil(cache.Reload())
+
+	_, err = cache.GetHintsFromExtraStateFile("pod", string(v1.ResourceCPU), availableNUMAs)
+	as.NotNil(err)
+}
+
+func TestExtraStateFileCacheWithoutDirectory(t *testing.T) {
+	t.Parallel()
+
+	as := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "checkpoint-TestExtraStateFileCacheWithoutDirectory")
+	as.Nil(err)
+	defer os.RemoveAll(tmpDir)
+
+	fileDir := filepath.Join(tmpDir, "extra")
+	fileAbsPath := filepath.Join(fileDir, "extra_state_file")
+	availableNUMAs := machine.NewCPUSet(0, 1, 2, 3)
+
+	cache := NewExtraStateFileCache(fileAbsPath, metrics.DummyMetrics{})
+	cache.reloadPeriod = 10 * time.Millisecond
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	as.Nil(cache.Run(stopCh))
+
+	_, err = cache.GetHintsFromExtraStateFile("pod", string(v1.ResourceCPU), availableNUMAs)
+	as.NotNil(err)
+
+	// the file is reloaded periodically once the directory is created after running
+	as.Nil(os.MkdirAll(fileDir, 0o755))
+	as.Nil(ioutil.WriteFile(fileAbsPath, []byte(`{"memoryEntries":{"pod-0":"1"}}`), 0o644))
+
+	as.Eventually(func() bool {
+		hints, err := cache.GetHintsFromExtraStateFile("pod", string(v1.ResourceCPU), availableNUMAs)
+		return err == nil && len(hints[string(v1.ResourceCPU)].Hints) == 1 &&
+			hints[string(v1.ResourceCPU)].Hints[0].String() == (&pluginapi.TopologyHint{Nodes: []uint64{1}, Preferred: true}).String()
+	}, time.Second, 10*time.Millisecond)
+}
@@ -16,11 +16,16 @@ limitations under the License.
 
 package qrm
 
+import "time"
+
 type CPUQRMPluginConfig struct {
 	// PolicyName is used to switch between several strategies
 	PolicyName string
 	// ReservedCPUCores indicates reserved cpus number for system agents
 	ReservedCPUCores int
+	// ReservedCPUCoresPerNUMA indicates reserved cpus number of specific NUMA nodes (keyed by NUMA id),
+	// it overrides the part of ReservedCPUCores in those NUMA nodes
+	ReservedCPUCoresPerNUMA map[int]int
 	// SkipCPUStateCorruption is set to skip cpu state corruption, and it will be used after updating state properties
 	SkipCPUStateCorruption bool
 
@@ -40,6 +45,41 @@ type CPUDynamicPolicyConfig struct {
 	EnableSyncingCPUIdle bool
 	// EnableCPUIdle indicates whether enabling cpu idle
 	EnableCPUIdle bool
+	// StateInvariantCheckPeriod is the interval to verify invariants of cpu plugin state, zero means disabled
+	StateInvariantCheckPeriod time.Duration
+	// EnableStateInvariantRebuild indicates whether to rebuild machine state from pod entries
+	// once state invariants are found to be violated
+	EnableStateInvariantRebuild bool
+	// EnableNonPreferredHintPromotion indicates whether to promote the best non-preferred hint
+	// to preferred when there is no preferred hint for dedicated_cores with NUMA binding
+	EnableNonPreferredHintPromotion bool
+	// NamespaceNUMABudget is the max count of NUMA nodes that NUMA-binding pods of each namespace
+	// can occupy collectively on this node, namespaces not in it are unlimited
+	NamespaceNUMABudget map[string]int
+	// SocketSelectionStrategy is the strategy (balance/pack) to choose among
+	// single-socket hints according to per-socket occupancy
+	SocketSelectionStrategy string
+	// EnableStrictNUMAExclusiveHints indicates whether to only generate hints composed of
+	// completely free NUMA nodes that are all needed for numa_exclusive containers spanning multiple NUMA nodes
+	EnableStrictNUMAExclusiveHints bool
+	// NUMAMemoryBandwidthBudget is the memory bandwidth (in MB/s) that dedicated_cores with NUMA binding
+	// can reserve in each NUMA node, hints exhausting it are non-preferred; zero means no budget
+	NUMAMemoryBandwidthBudget int64
+	// NUMAAllocationStrategy is the strategy (binpacking/spread) to order equally-sized preferred hints
+	// by available cpus, it replaces HintPreferenceStrategy, and empty means HintPreferenceStrategy is used
+	NUMAAllocationStrategy string
+	// EnableReclaimedDisplacementReport indicates whether to report the count of reclaimed cpus
+	// that would be displaced by each hint of dedicated_cores with NUMA binding in hints response
+	EnableReclaimedDisplacementReport bool
+	// HintPreferenceStrategy is the name of registered strategy (e.g. min_numas/load_balancing) to decide
+	// preferred hints and their order, empty means min_numas
+	HintPreferenceStrategy string
+	// BurstReservedNUMAsPerSocket is the count of NUMA nodes with the least allocated cpus in each socket
+	// kept unallocated as burst headroom, they are only used if no other placement exists
+	BurstReservedNUMAsPerSocket int
+	// CrossSocketPolicy is the policy (prefer_same_socket/forbid_cross_socket/allow_cross_socket)
+	// to decide whether masks crossing sockets are rejected, empty means prefer_same_socket
+	CrossSocketPolicy string
 }
 
 type CPUNativePolicyConfig struct {
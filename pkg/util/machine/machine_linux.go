@@ -26,6 +26,7 @@ import (
 	info "github.com/google/cadvisor/info/v1"
 	"github.com/google/cadvisor/machine"
 	"github.com/google/cadvisor/utils/sysfs"
+	"k8s.io/klog/v2"
 
 	"github.com/kubewharf/katalyst-core/pkg/config/agent/global"
 )
@@ -43,6 +44,14 @@ func GetKatalystMachineInfo(conf *global.MachineInfoConfiguration) (*KatalystMac
 		return nil, err
 	}
 
+	// uniform distances are used if NUMA distances can't be discovered
+	numaDistances, err := GetNUMADistances(cpuTopology.CPUDetails.NUMANodes())
+	if err != nil {
+		klog.Warningf("GetNUMADistances failed with error: %v", err)
+	} else {
+		cpuTopology.NUMADistances = numaDistances
+	}
+
 	extraCPUInfo, err := GetExtraCPUInfo()
 	if err != nil {
 		return nil, err
@@ -18,12 +18,25 @@ package machine
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	info "github.com/google/cadvisor/info/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
 )
 
+const (
+	// LocalNUMADistance and RemoteNUMADistance are the default distances defined by ACPI SLIT,
+	// they are used as uniform distances if NUMA distances aren't discovered.
+	LocalNUMADistance  = 10
+	RemoteNUMADistance = 20
+
+	numaNodeSysFsDir = "/sys/devices/system/node"
+)
+
 // NUMANodeInfo is a map from NUMANode ID to a list of
 // CPU IDs associated with that NUMANode.
 type NUMANodeInfo map[int]CPUSet
@@ -42,6 +55,9 @@ type CPUTopology struct {
 	NumSockets   int
 	NumNUMANodes int
 	CPUDetails   CPUDetails
+	// NUMADistances is a map from NUMA id to its distances (ACPI SLIT) to each NUMA,
+	// it may be empty if distances aren't discovered.
+	NUMADistances map[int]map[int]int
 }
 
 type MemoryDetails map[int]uint64
@@ -89,6 +105,30 @@ func (topo *CPUTopology) NUMAsPerSocket() (int, error) {
 	return numasCount / topo.NumSockets, nil
 }
 
+// GetNUMADistance returns the distance between the given NUMA nodes,
+// and falls back to uniform distances if it isn't discovered.
+func (topo *CPUTopology) GetNUMADistance(from, to int) int {
+	if distance, ok := topo.NUMADistances[from][to]; ok {
+		return distance
+	}
+
+	if from == to {
+		return LocalNUMADistance
+	}
+	return RemoteNUMADistance
+}
+
+// GetNUMAsTotalDistance returns the sum of distances between each pair of the given NUMA nodes
+func (topo *CPUTopology) GetNUMAsTotalDistance(numaNodes []int) int {
+	total := 0
+	for i := range numaNodes {
+		for j := i + 1; j < len(numaNodes); j++ {
+			total += topo.GetNUMADistance(numaNodes[i], numaNodes[j])
+		}
+	}
+	return total
+}
+
 // GetSocketTopology parses the given CPUTopology to a mapping
 // from socket id to cpu id lists
 func (topo *CPUTopology) GetSocketTopology() map[int]string {
@@ -367,6 +407,41 @@ func Discover(machineInfo *info.MachineInfo) (*CPUTopology, *MemoryTopology, err
 	}, &memoryTopology, nil
 }
 
+// GetNUMADistances reads distances of the given NUMA nodes from sysfs, the distance file
+// of each NUMA lists its distances to all NUMA nodes in ascending order of NUMA id.
+func GetNUMADistances(numaNodes CPUSet) (map[int]map[int]int, error) {
+	return getNUMADistances(numaNodeSysFsDir, numaNodes)
+}
+
+func getNUMADistances(nodeDir string, numaNodes CPUSet) (map[int]map[int]int, error) {
+	numaIDs := numaNodes.ToSliceInt()
+
+	distances := make(map[int]map[int]int, len(numaIDs))
+	for _, from := range numaIDs {
+		distanceFile := filepath.Join(nodeDir, fmt.Sprintf("node%d", from), "distance")
+		content, err := ioutil.ReadFile(distanceFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %s failed with error: %v", distanceFile, err)
+		}
+
+		fields := strings.Fields(string(content))
+		if len(fields) != len(numaIDs) {
+			return nil, fmt.Errorf("%s has %d distances, but there are %d NUMA nodes", distanceFile, len(fields), len(numaIDs))
+		}
+
+		distances[from] = make(map[int]int, len(numaIDs))
+		for i, field := range fields {
+			distance, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("parse distance: %s in %s failed with error: %v", field, distanceFile, err)
+			}
+			distances[from][numaIDs[i]] = distance
+		}
+	}
+
+	return distances, nil
+}
+
 // getUniqueCoreID computes coreId as the lowest cpuID
 // for a given Threads []int slice. This will assure that coreID's are
 // platform unique (opposite to what cAdvisor reports)
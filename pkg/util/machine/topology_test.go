@@ -0,0 +1,55 @@
+/*
+Copyright 2022 The Katalyst Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNUMADistances(t *testing.T) {
+	t.Parallel()
+
+	nodeDir, err := ioutil.TempDir("", "TestGetNUMADistances")
+	assert.NoError(t, err)
+	defer os.RemoveAll(nodeDir)
+
+	for numaID, content := range map[string]string{"node0": "10 21\n", "node1": "21 10\n"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(nodeDir, numaID), 0o755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(nodeDir, numaID, "distance"), []byte(content), 0o644))
+	}
+
+	distances, err := getNUMADistances(nodeDir, NewCPUSet(0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]map[int]int{0: {0: 10, 1: 21}, 1: {0: 21, 1: 10}}, distances)
+
+	_, err = getNUMADistances(nodeDir, NewCPUSet(0, 1, 2))
+	assert.Error(t, err)
+
+	topology := &CPUTopology{NUMADistances: distances}
+	assert.Equal(t, 21, topology.GetNUMADistance(0, 1))
+	assert.Equal(t, 21, topology.GetNUMAsTotalDistance([]int{0, 1}))
+
+	// uniform distances are used if not discovered
+	topology = &CPUTopology{}
+	assert.Equal(t, LocalNUMADistance, topology.GetNUMADistance(1, 1))
+	assert.Equal(t, 3*RemoteNUMADistance, topology.GetNUMAsTotalDistance([]int{0, 1, 2}))
+}